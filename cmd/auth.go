@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+)
+
+// authCmd represents the auth command
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Inspect and manage cached access tokens",
+	Long: `Access tokens retrieved with the oauthid and oauthsecret are cached in
+~/.hipchat-cli/tokens.json and reused until shortly before they expire.
+
+status: show the cached tokens and when they expire
+clear:  remove all cached tokens
+`,
+}
+
+// authStatusCmd represents the auth status command
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show cached access tokens and their expiry",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokens, err := internal.ReadTokenCache()
+		if err != nil {
+			return err
+		}
+
+		file, err := internal.TokenCacheFile()
+		if err != nil {
+			return err
+		}
+
+		// The access tokens themselves are never shown.
+		statuses := []tokenStatus{}
+		result := internal.Result{Header: []string{"OAUTHID", "ENDPOINT", "SCOPES", "EXPIRES", "STATUS"}}
+		keys := make([]string, 0, len(tokens))
+		for k := range tokens {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return tokens[keys[i]].ExpiresAt.Before(tokens[keys[j]].ExpiresAt) })
+
+		for _, k := range keys {
+			t := tokens[k]
+			status := "expired"
+			if t.Valid() {
+				status = fmt.Sprintf("valid for %v", time.Until(t.ExpiresAt).Truncate(time.Second))
+			}
+			statuses = append(statuses, tokenStatus{t.OAuthID, t.Endpoint, t.Scopes, t.ObtainedAt, t.ExpiresAt, t.Valid()})
+			result.Rows = append(result.Rows, []string{t.OAuthID, t.Endpoint, strings.Join(t.Scopes, ","), t.ExpiresAt.Format(time.RFC3339), status})
+		}
+		result.Value = statuses
+
+		if len(tokens) == 0 {
+			result.Text = func(w io.Writer) error {
+				_, err := fmt.Fprintf(w, "No cached tokens in %v\n", file)
+				return err
+			}
+		}
+		return internal.PrintResult(result)
+	},
+}
+
+// authClearCmd represents the auth clear command
+var authClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached access tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := internal.TokenCacheFile()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	},
+}
+
+// tokenStatus describes a cached token in the output of auth status.
+type tokenStatus struct {
+	OAuthID    string    `json:"oauthid"`
+	Endpoint   string    `json:"endpoint"`
+	Scopes     []string  `json:"scopes"`
+	ObtainedAt time.Time `json:"obtained_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Valid      bool      `json:"valid"`
+}
+
+func init() {
+	RootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authStatusCmd)
+	authCmd.AddCommand(authClearCmd)
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// backupScopes are the OAuth scopes needed to back up rooms.
+var backupScopes = []string{hipchat.ScopeViewRoom, hipchat.ScopeViewMessages}
+
+// backupResult is the result of backing up a room.
+type backupResult struct {
+	Room     string `json:"room"`
+	Added    int    `json:"added"`
+	Messages int    `json:"messages"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+
+	err error
+}
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the history of rooms to a directory",
+	Long: `Backs up the history of rooms to --dir, with the files and cards of the messages.
+
+--rooms is all for all rooms, archived and private rooms included, or a comma
+separated list of rooms and groups of rooms.
+
+Every room gets a directory with a JSON file per day, holding the messages sent
+that day in UTC, oldest first. manifest.json lists the rooms and up to which
+message they are backed up, so running backup again only adds the messages
+sent since. An interrupted backup continues where it stopped.
+
+Rooms are backed up one at a time. When the rate limit of HipChat is exhausted
+backup waits until it is reset.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := cmd.Flag("dir").Value.String()
+		if dir == "" {
+			return internal.UsageErrorf("Specification of a directory is mandatory, use --dir")
+		}
+		value := cmd.Flag("rooms").Value.String()
+		if value == "" {
+			return internal.UsageErrorf("Specification of the rooms is mandatory, use --rooms")
+		}
+
+		b, err := internal.OpenBackup(dir)
+		if err != nil {
+			return err
+		}
+		c, err := internal.GetClient(backupScopes...)
+		if err != nil {
+			return err
+		}
+
+		var rooms []hipchat.Room
+		if value == "all" {
+			if rooms, err = listRooms(c, "", true, 0); err != nil {
+				return err
+			}
+		} else {
+			names, err := internal.SplitRooms(value)
+			if err != nil {
+				return internal.WithExitCode(internal.ExitUsage, err)
+			}
+			for _, name := range names {
+				r, err := getRoom(c, name)
+				if err != nil {
+					return fmt.Errorf("Error while getting room %v: %w", name, err)
+				}
+				rooms = append(rooms, r.Room)
+			}
+		}
+
+		results := make([]backupResult, len(rooms))
+		var failed []backupResult
+		result := internal.Result{Value: results, Header: []string{"ROOM", "ADDED", "MESSAGES", "STATUS", "ERROR"}}
+		for i, r := range rooms {
+			results[i] = backupRoom(c, b, r)
+			res := results[i]
+			result.Rows = append(result.Rows, []string{res.Room, strconv.Itoa(res.Added), strconv.Itoa(res.Messages), res.Status, res.Error})
+			if res.err != nil {
+				internal.LogError(fmt.Errorf("Could not back up room %v: %w", r.Name, res.err))
+				failed = append(failed, res)
+			}
+		}
+		if err := internal.PrintResult(result); err != nil {
+			return err
+		}
+
+		switch {
+		case len(failed) == 0:
+			return nil
+		case len(failed) == len(rooms):
+			return fmt.Errorf("Could not back up any of the %d rooms: %w", len(rooms), failed[0].err)
+		default:
+			names := make([]string, len(failed))
+			for i, r := range failed {
+				names[i] = r.Room
+			}
+			return internal.WithExitCode(internal.ExitPartial, fmt.Errorf("Could not back up %d of %d rooms: %v", len(failed), len(rooms), strings.Join(names, ", ")))
+		}
+	},
+}
+
+// backupRoom adds the messages of r sent since its last backup to b.
+func backupRoom(c *hipchat.Client, b *internal.Backup, r hipchat.Room) backupResult {
+	state := b.Room(r.ID, r.Name)
+	state.Name, state.Privacy, state.IsArchived = r.Name, r.Privacy, r.IsArchived
+	result := backupResult{Room: r.Name, Status: statusFailed}
+
+	since := time.Time{}
+	if state.LastMessageDate != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, state.LastMessageDate); err != nil {
+			result.err = fmt.Errorf("invalid last_message_date in the manifest: %v", err)
+			result.Error = result.err.Error()
+			return result
+		}
+		internal.Infof("Backing up room %v since %v", r.Name, state.LastMessageDate)
+	} else {
+		internal.Infof("Backing up room %v", r.Name)
+	}
+
+	// Pages are newest first, so the first message is the newest. It is only
+	// recorded once all messages are stored, so an interrupted backup of the
+	// room starts again at the same message.
+	var newest *internal.HistoryMessage
+	err := fetchHistory(c, strconv.Itoa(r.ID), since, time.Now(), "", 0, func(page []internal.HistoryMessage) error {
+		if newest == nil && len(page) > 0 {
+			newest = &page[0]
+		}
+		added, err := b.AddMessages(state, page)
+		result.Added += added
+		if err != nil {
+			return err
+		}
+		return b.Save()
+	})
+	if err == nil {
+		if newest != nil {
+			state.LastMessageID, state.LastMessageDate = newest.ID, newest.Date
+		}
+		state.Updated = time.Now().UTC()
+		err = b.Save()
+	}
+
+	result.Messages = state.Messages
+	if err != nil {
+		result.err = err
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = "ok"
+	return result
+}
+
+func init() {
+	RootCmd.AddCommand(backupCmd)
+
+	requiresScopes(backupCmd, backupScopes...)
+
+	backupCmd.Flags().String("rooms", "all", "Rooms to back up: all, or a comma separated list of rooms and groups")
+	backupCmd.Flags().String("dir", "", "Directory to store the backup in")
+}
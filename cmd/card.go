@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/nu7hatch/gouuid"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// attributeFlag is a repeatable flag of card attributes, given as
+// label=value[,url=<url>][,style=<style>].
+type attributeFlag []hipchat.Attribute
+
+func (f *attributeFlag) String() string {
+	values := make([]string, len(*f))
+	for i, a := range *f {
+		values[i] = a.Label + "=" + a.Value.Label
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+func (f *attributeFlag) Type() string {
+	return "label=value"
+}
+
+func (f *attributeFlag) Set(value string) error {
+	parts := strings.Split(value, ",")
+	label := strings.SplitN(parts[0], "=", 2)
+	if len(label) != 2 || label[0] == "" {
+		return fmt.Errorf("should be label=value[,url=<url>][,style=<style>]")
+	}
+
+	a := hipchat.Attribute{Label: label[0], Value: hipchat.AttributeValue{Label: label[1]}}
+	for _, p := range parts[1:] {
+		switch {
+		case strings.HasPrefix(p, "url="):
+			a.Value.URL = strings.TrimPrefix(p, "url=")
+		case strings.HasPrefix(p, "style="):
+			a.Value.Style = strings.TrimPrefix(p, "style=")
+		default:
+			// A comma in the value
+			a.Value.Label += "," + p
+		}
+	}
+	*f = append(*f, a)
+	return nil
+}
+
+// cardFlags are the flags that build a card, --card and --card-file excluded.
+var cardFlags = []string{"card-style", "card-title", "card-url", "card-description", "card-description-format",
+	"card-icon", "card-thumbnail", "card-activity", "card-format", "card-id", "attribute"}
+
+// buildCard returns the card given by --card-file or the card flags, or nil
+// if no card is given. The card is validated.
+func buildCard(cmd *cobra.Command) (*hipchat.Card, error) {
+	builder := cmd.Flag("card").Changed
+	for _, name := range cardFlags {
+		builder = builder || cmd.Flag(name).Changed
+	}
+	file := cmd.Flag("card-file").Value.String()
+	if builder && file != "" {
+		return nil, internal.UsageErrorf("use either --card-file or the --card flags")
+	}
+
+	var card *hipchat.Card
+	switch {
+	case file != "":
+		var err error
+		if card, err = internal.ReadCardFile(file); err != nil {
+			return nil, internal.WithExitCode(internal.ExitUsage, err)
+		}
+	case builder:
+		card = &hipchat.Card{
+			Style:      cmd.Flag("card-style").Value.String(),
+			Title:      cmd.Flag("card-title").Value.String(),
+			URL:        cmd.Flag("card-url").Value.String(),
+			Format:     cmd.Flag("card-format").Value.String(),
+			ID:         cmd.Flag("card-id").Value.String(),
+			Attributes: *cmd.Flag("attribute").Value.(*attributeFlag),
+		}
+		if description := cmd.Flag("card-description").Value.String(); description != "" {
+			card.Description = hipchat.CardDescription{Format: cmd.Flag("card-description-format").Value.String(), Value: description}
+		}
+		if icon := cmd.Flag("card-icon").Value.String(); icon != "" {
+			card.Icon = &hipchat.Icon{URL: icon}
+		}
+		if thumbnail := cmd.Flag("card-thumbnail").Value.String(); thumbnail != "" {
+			card.Thumbnail = &hipchat.Icon{URL: thumbnail}
+		}
+		if activity := cmd.Flag("card-activity").Value.String(); activity != "" {
+			card.Activity = &hipchat.Activity{HTML: activity}
+		}
+	default:
+		return nil, nil
+	}
+	return completeCard(card)
+}
+
+// completeCard gives card an ID if it has none, and validates it.
+func completeCard(card *hipchat.Card) (*hipchat.Card, error) {
+	if card.ID == "" {
+		id, err := cardID(card)
+		if err != nil {
+			return nil, err
+		}
+		card.ID = id.String()
+	}
+	if err := internal.ValidateCard(card); err != nil {
+		return nil, internal.WithExitCode(internal.ExitUsage, err)
+	}
+	return card, nil
+}
+
+// cardID returns a random ID for a card, or one derived from the JSON of
+// seed in a dry run so its output can be compared between runs.
+func cardID(seed interface{}) (*uuid.UUID, error) {
+	if internal.DryRun() {
+		data, err := json.Marshal(seed)
+		if err != nil {
+			return nil, err
+		}
+		return uuid.NewV5(uuid.NamespaceURL, data)
+	}
+	return uuid.NewV4()
+}
+
+// addCardFlags adds the flags read by buildCard to cmd.
+func addCardFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("card", false, "Send a card built from the --card flags")
+	cmd.Flags().String("card-file", "", "JSON or YAML file with the card to send, - reads JSON from stdin")
+	cmd.Flags().String("card-style", hipchat.CardStyleApplication, "Style of the card: file, image, application, link or media")
+	cmd.Flags().String("card-title", "", "Title of the card")
+	cmd.Flags().String("card-url", "", "URL the title of the card links to")
+	cmd.Flags().String("card-description", "", "Description of the card")
+	cmd.Flags().String("card-description-format", "html", "Format of the description: html or text")
+	cmd.Flags().String("card-icon", "", "URL of the icon of the card")
+	cmd.Flags().String("card-thumbnail", "", "URL of the thumbnail of the card")
+	cmd.Flags().String("card-activity", "", "HTML of the activity shown for the card")
+	cmd.Flags().String("card-format", "", "Format of the card: compact or medium")
+	cmd.Flags().String("card-id", "", "ID of the card (default random)")
+	cmd.Flags().Var(&attributeFlag{}, "attribute", "Attribute of the card as label=value[,url=<url>][,style=<style>], can be repeated")
+}
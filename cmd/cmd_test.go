@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/houtmanj/hipchat-cli/internal/fakehipchat"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// TestMain runs the tests with a config file using OAuth in a temporary
+// home directory, so the token cache and the outbox are not shared.
+func TestMain(m *testing.M) {
+	home, err := ioutil.TempDir("", "hipchat-cli-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config := "oauthid: test\noauthsecret: secret\n"
+	if err := ioutil.WriteFile(filepath.Join(home, ".hipchat-cli.yaml"), []byte(config), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("HIPCHAT_RETRY_MAX_ATTEMPTS", "1")
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// startFake starts a fake HipChat server with rooms and points the endpoint
+// at it. The returned function stops the server.
+func startFake(rooms ...string) (*fakehipchat.Server, func()) {
+	fake := fakehipchat.New()
+	for _, room := range rooms {
+		fake.AddRoom(room, "")
+	}
+	server := httptest.NewServer(fake)
+	os.Setenv("HIPCHAT_ENDPOINT", server.URL+"/v2")
+	return fake, func() {
+		server.Close()
+		os.Unsetenv("HIPCHAT_ENDPOINT")
+	}
+}
+
+// run runs hipchat-cli with args like Execute, and returns what the command
+// wrote to stdout and its error. The flags are reset afterwards, so they do
+// not apply to tests calling the commands' functions directly.
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	resetFlags(RootCmd)
+	defer resetFlags(RootCmd)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+
+	RootCmd.SetArgs(args)
+	err = execute()
+	w.Close()
+	os.Stdout = stdout
+	return <-out, err
+}
+
+// resetFlags sets the flags changed by a previous run back to their defaults,
+// as cobra keeps them. String slices cannot be reset, the tests do not use them.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		switch v := f.Value.(type) {
+		case *roomValue:
+			*v = nil
+		case *varFlag:
+			*v = varFlag{}
+		case *attributeFlag:
+			*v = nil
+		default:
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
+
+// checkExitCode fails t if err does not result in exit code want.
+func checkExitCode(t *testing.T, err error, want int) {
+	t.Helper()
+	if got := internal.ExitCode(err); got != want {
+		t.Fatalf("exit code = %d, want %d (error: %v)", got, want, err)
+	}
+}
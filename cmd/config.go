@@ -0,0 +1,353 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+	"gopkg.in/yaml.v2"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and change the configuration",
+	Long: `Allows you to inspect and change the configuration file. For example:
+
+init:     create a new config file
+get:      show the value of a setting
+set:      change a setting in the config file
+unset:    remove a setting from the config file
+validate: check the config file for mistakes
+path:     show the location of the config file
+profiles: list the profiles defined in the config file
+set-secret: store the oauthsecret or token in the secret store
+
+Keys of nested settings are separated by dots, like profiles.prod.endpoint.
+When --profile is given, set and unset change the settings of that profile.
+`,
+}
+
+// configInitCmd represents the config init command
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a new config file",
+	Long: `Creates a config file, readable only by you, with the given settings.
+An existing config file is only overwritten when --force is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := internal.ConfigFile()
+		if err != nil {
+			return err
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		if _, err := os.Stat(file); err == nil && !force {
+			return fmt.Errorf("%v already exists, use --force to overwrite it", file)
+		}
+
+		config := yaml.MapSlice{}
+		for _, key := range []string{"oauthid", "oauthsecret", "token", "endpoint", "proxy"} {
+			if value := cmd.Flag(key).Value.String(); value != "" {
+				config = append(config, yaml.MapItem{Key: key, Value: value})
+			}
+		}
+
+		if err := internal.WriteConfigFile(file, config); err != nil {
+			return err
+		}
+		internal.Infof("Created %v", file)
+		return nil
+	},
+}
+
+// configGetCmd represents the config get command
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Show the value of a setting",
+	Long: `Shows the value of a setting as used by hipchat-cli, taking into account
+the active profile, environment variables and flags.
+Secrets are masked unless --show-secrets is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return internal.UsageErrorf("specify the key to show")
+		}
+		key := args[0]
+
+		if !viper.IsSet(key) {
+			return fmt.Errorf("%v is not set", key)
+		}
+		value := viper.Get(key)
+
+		if showSecrets, _ := cmd.Flags().GetBool("show-secrets"); !showSecrets {
+			if internal.IsSecretKey(key[strings.LastIndex(key, ".")+1:]) {
+				value = internal.MaskSecret(fmt.Sprint(value))
+			} else {
+				value = internal.MaskSecrets(value)
+			}
+		}
+
+		if _, ok := value.(map[interface{}]interface{}); ok {
+			value = internal.StringMap(value)
+		}
+
+		return internal.PrintResult(internal.Result{
+			Value:  value,
+			Header: []string{"KEY", "VALUE"},
+			Rows:   [][]string{{key, fmt.Sprint(value)}},
+			Text: func(w io.Writer) error {
+				switch value.(type) {
+				case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+					data, err := yaml.Marshal(value)
+					if err != nil {
+						return err
+					}
+					_, err = w.Write(data)
+					return err
+				default:
+					_, err := fmt.Fprintln(w, value)
+					return err
+				}
+			},
+		})
+	},
+}
+
+// configSetCmd represents the config set command
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting in the config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return internal.UsageErrorf("specify the key and the value to set")
+		}
+
+		key := configKey(cmd, args[0])
+		if name := args[0][:strings.Index(args[0]+".", ".")]; !internal.IsKnownKey(name) {
+			internal.Warnf("%v is not a known setting", name)
+		}
+
+		return updateConfigFile(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+			return internal.SetConfigKey(config, key, args[1])
+		})
+	},
+}
+
+// configUnsetCmd represents the config unset command
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a setting from the config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return internal.UsageErrorf("specify the key to remove")
+		}
+
+		key := configKey(cmd, args[0])
+		return updateConfigFile(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+			config, found := internal.UnsetConfigKey(config, key)
+			if !found {
+				return nil, fmt.Errorf("%v is not set in the config file", key)
+			}
+			return config, nil
+		})
+	},
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for mistakes",
+	Long: `Checks the endpoint and proxy URLs, unknown keys, the presence of credentials
+and the permissions of the config file.
+
+Problems are written to stderr. The permissions of the files set with
+oauthsecret_file, token_file and secrets_key_file are checked too.
+
+With --check-auth the credentials are verified by retrieving an access token,
+or for a personal token by listing a room.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := internal.ConfigFile()
+		if err != nil {
+			return err
+		}
+
+		problems, err := internal.ValidateConfigFile(file)
+		if err != nil {
+			return err
+		}
+
+		errors := 0
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+			if p.Fatal {
+				errors++
+			}
+		}
+		if errors > 0 {
+			return internal.UsageErrorf("%v contains %v error(s)", file, errors)
+		}
+
+		if checkAuth, _ := cmd.Flags().GetBool("check-auth"); checkAuth {
+			// A cached access token would not show that the credentials still work
+			cmd.Flags().Set("no-token-cache", "true")
+			c, err := internal.GetClient(notifyScopes...)
+			if err != nil {
+				return fmt.Errorf("authentication failed: %w", err)
+			}
+			if internal.AuthMode() == internal.AuthModeToken {
+				// A personal token is only refused once it is used. Lacking the
+				// scope to list rooms still shows the token is valid.
+				if err := checkToken(c); err != nil && internal.ExitCode(err) != internal.ExitPermission {
+					return fmt.Errorf("authentication failed: %w", err)
+				}
+			}
+			fmt.Println("authentication succeeded")
+		}
+
+		fmt.Println(file, "is valid")
+		return nil
+	},
+}
+
+// checkToken makes the cheapest authenticated request, listing one room.
+func checkToken(c *hipchat.Client) error {
+	req, err := c.NewRequest("GET", "room?max-results=1", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req, nil)
+	return internal.CheckResponse(resp, err)
+}
+
+// configSetSecretCmd represents the config set-secret command
+var configSetSecretCmd = &cobra.Command{
+	Use:   "set-secret [oauthsecret|token]",
+	Short: "Store the oauthsecret or token in the secret store",
+	Long: `Reads a secret from stdin and stores it in the secret store, so it does not
+have to be in the config file. The secret defaults to the oauthsecret.
+
+The store is selected with the secret_backend config key:
+secret-service: the Secret Service (GNOME Keyring, KWallet) through secret-tool
+file:           ~/.hipchat-cli/secrets.enc, encrypted with a key derived from
+                HIPCHAT_SECRETS_PASSPHRASE or a random key in ~/.hipchat-cli/secrets.key,
+                or in the file set with secrets_key_file
+auto:           secret-service if secret-tool is installed, file otherwise (default)
+
+The chosen backend is stored in the config file. Secrets are kept per profile.
+
+Example:
+pass show hipchat | hipchat-cli config set-secret oauthsecret`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := "oauthsecret"
+		if len(args) > 0 {
+			key = args[0]
+		}
+		if key != "oauthsecret" && key != "token" {
+			return internal.UsageErrorf("only the oauthsecret or token can be stored as secret")
+		}
+
+		backend := viper.GetString("secret_backend")
+		if backend == "" || backend == internal.SecretBackendAuto {
+			backend = internal.DetectSecretBackend()
+		}
+		store, err := internal.NewSecretStore(backend)
+		if err != nil {
+			return err
+		}
+
+		if del, _ := cmd.Flags().GetBool("delete"); del {
+			return store.Delete(internal.SecretName(key))
+		}
+
+		secret, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		secret = strings.TrimRight(secret, "\r\n")
+		if secret == "" {
+			return internal.UsageErrorf("no secret given on stdin")
+		}
+
+		if err := store.Set(internal.SecretName(key), secret); err != nil {
+			return fmt.Errorf("Could not store %v: %v", key, err)
+		}
+
+		if viper.GetString("secret_backend") != backend {
+			return updateConfigFile(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+				return internal.SetConfigKey(config, configKey(cmd, "secret_backend"), backend)
+			})
+		}
+		return nil
+	},
+}
+
+// configPathCmd represents the config path command
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Show the location of the config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := internal.ConfigFile()
+		if err != nil {
+			return err
+		}
+		fmt.Println(file)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configPathCmd)
+	configCmd.AddCommand(configSetSecretCmd)
+
+	configInitCmd.Flags().String("oauthid", "", "oauthid of the add-on")
+	configInitCmd.Flags().String("oauthsecret", "", "oauthsecret of the add-on")
+	configInitCmd.Flags().String("token", "", "personal or room API token, instead of oauthid and oauthsecret")
+	configInitCmd.Flags().String("endpoint", "", "url of the HipChat API, like https://hipchat.example.com/v2/")
+	configInitCmd.Flags().String("proxy", "", "url of the proxy to use")
+	configInitCmd.Flags().Bool("force", false, "Overwrite an existing config file")
+
+	configGetCmd.Flags().Bool("show-secrets", false, "Show secrets instead of masking them")
+
+	configSetSecretCmd.Flags().Bool("delete", false, "Remove the secret from the secret store")
+
+	configValidateCmd.Flags().Bool("check-auth", false, "Verify the credentials by retrieving an access token or using the token")
+}
+
+// configKey returns the key to change in the config file, which is within
+// the profile given with --profile if any.
+func configKey(cmd *cobra.Command, key string) string {
+	if cmd.Flag("profile").Changed {
+		return "profiles." + cmd.Flag("profile").Value.String() + "." + key
+	}
+	return key
+}
+
+// updateConfigFile reads the config file, applies update and writes the result.
+func updateConfigFile(update func(yaml.MapSlice) (yaml.MapSlice, error)) error {
+	file, err := internal.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	config, err := internal.ReadConfigFile(file)
+	if err != nil {
+		return err
+	}
+
+	config, err = update(config)
+	if err != nil {
+		return err
+	}
+	return internal.WriteConfigFile(file, config)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+func TestConfigValidateCheckAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		// token is the personal token configured, with scopes
+		token  string
+		scopes []string
+		code   int
+	}{
+		{name: "access token", code: internal.ExitOK},
+		{name: "personal token", token: "personal", scopes: []string{hipchat.ScopeViewGroup}, code: internal.ExitOK},
+		{name: "personal token without scope to list rooms", token: "personal", scopes: []string{hipchat.ScopeSendNotification}, code: internal.ExitOK},
+		{name: "unknown personal token", token: "unknown", code: internal.ExitAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, stop := startFake("ops")
+			defer stop()
+			if tt.scopes != nil {
+				fake.AddToken(tt.token, tt.scopes...)
+			}
+			if tt.token != "" {
+				os.Setenv("HIPCHAT_TOKEN", tt.token)
+				defer os.Unsetenv("HIPCHAT_TOKEN")
+			}
+
+			_, err := run(t, "config", "validate", "--check-auth")
+			checkExitCode(t, err, tt.code)
+		})
+	}
+}
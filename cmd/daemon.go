@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/viper"
+)
+
+// daemonNotification is the payload of POST /v1/notify.
+type daemonNotification struct {
+	Room string `json:"room"`
+	internal.Notification
+}
+
+// daemonResponse is the response of the daemon to a notification.
+type daemonResponse struct {
+	ID       string `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// daemonUnreachableError is returned when no daemon is listening.
+type daemonUnreachableError struct {
+	err error
+}
+
+func (e daemonUnreachableError) Error() string {
+	return fmt.Sprintf("Could not reach the hipchat-cli daemon: %v", e.err)
+}
+
+// daemonSocket returns the path of the unix socket of the daemon.
+func daemonSocket() (string, error) {
+	if socket := viper.GetString("daemon_socket"); socket != "" {
+		return socket, nil
+	}
+	dir, err := internal.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// daemonClient returns a client for the daemon and its base URL. The unix
+// socket is used if it exists, otherwise daemon_http.
+func daemonClient() (*http.Client, string, error) {
+	socket, err := daemonSocket()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		dialer := net.Dialer{Timeout: 5 * time.Second}
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://hipchat-cli", nil
+	}
+
+	if addr := viper.GetString("daemon_http"); addr != "" {
+		return &http.Client{}, "http://" + addr, nil
+	}
+	return nil, "", daemonUnreachableError{fmt.Errorf("%v does not exist and daemon_http is not set", socket)}
+}
+
+// notListening reports if err is the failure to connect to a daemon that is
+// not running: the socket does not exist or the connection was refused.
+func notListening(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		return false
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT)
+}
+
+// sendViaDaemon sends n to room through the daemon and waits until it is delivered.
+func sendViaDaemon(room string, n *internal.Notification) error {
+	c, base, err := daemonClient()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(daemonNotification{Room: room, Notification: *n})
+	if err != nil {
+		return err
+	}
+
+	internal.Debugf("Sending notification to %v through the daemon at %v", room, base)
+	resp, err := c.Post(base+"/v1/notify?wait=1", "application/json", bytes.NewReader(body))
+	if err != nil {
+		if notListening(err) {
+			return daemonUnreachableError{err}
+		}
+		// The daemon may have received the notification, so sending it
+		// directly could send it twice
+		return fmt.Errorf("Error while sending notification through the daemon: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	var r daemonResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil || r.Error == "" {
+		return fmt.Errorf("the daemon returned status %v", resp.Status)
+	}
+	code := r.ExitCode
+	if code == internal.ExitOK {
+		code = internal.ExitError
+	}
+	return internal.WithExitCode(code, fmt.Errorf("%v", r.Error))
+}
@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http/httputil"
+	"strings"
+	"sync"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// spoolFlag is the --spool flag shared by all commands sending notifications.
+var spoolFlag = newSpoolFlag()
+
+func newSpoolFlag() *pflag.Flag {
+	flags := pflag.NewFlagSet("spool", pflag.ContinueOnError)
+	flags.Bool("spool", false, "Keep the notification in the outbox if HipChat cannot be reached, see: hipchat-cli outbox")
+	return flags.Lookup("spool")
+}
+
+// viaDaemonFlag is the --via-daemon flag shared by all commands sending notifications.
+var viaDaemonFlag = newViaDaemonFlag()
+
+func newViaDaemonFlag() *pflag.Flag {
+	flags := pflag.NewFlagSet("via-daemon", pflag.ContinueOnError)
+	flags.Bool("via-daemon", false, "Send the notification through a running hipchat-cli serve, see: hipchat-cli serve --help")
+	return flags.Lookup("via-daemon")
+}
+
+// parallelFlag is the --parallel flag shared by all commands sending notifications.
+var parallelFlag = newParallelFlag()
+
+func newParallelFlag() *pflag.Flag {
+	flags := pflag.NewFlagSet("parallel", pflag.ContinueOnError)
+	flags.Int("parallel", defaultParallel, "Number of rooms and users to send to at the same time")
+	return flags.Lookup("parallel")
+}
+
+// defaultParallel is the default of the parallel config key.
+const defaultParallel = 4
+
+// Statuses of the result of sending to a room.
+const (
+	statusSent    = "sent"
+	statusSpooled = "spooled"
+	statusFailed  = "failed"
+)
+
+// targetResult is the result of sending notifications to a room or user.
+type targetResult struct {
+	Room   string `json:"room,omitempty"`
+	User   string `json:"user,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	err error
+}
+
+// target returns the room or user the result is for.
+func (r targetResult) target() string {
+	if r.User != "" {
+		return "user " + r.User
+	}
+	return r.Room
+}
+
+// broadcast sends the notifications, in order, to every room, and as private
+// messages to every user. Up to the parallel setting targets are sent to at
+// the same time, using a single client. When sending to multiple targets a
+// summary of the results is printed, and an error with ExitPartial is
+// returned if only some targets failed.
+func broadcast(rooms, users []string, notifications []*internal.Notification) error {
+	s := &notifier{scopes: notifyScopes}
+	if len(users) > 0 {
+		// An add-on cannot send private messages, so do not request an access token for it
+		mode, err := internal.ConfiguredAuthMode()
+		if err != nil {
+			return err
+		}
+		if mode == internal.AuthModeOAuth {
+			return internal.WithExitCode(internal.ExitAuth, fmt.Errorf("Sending private messages with --user needs a personal access token in the token key of the config file, "+
+				"the oauthid and oauthsecret of an add-on can only send notifications to rooms"))
+		}
+		s.scopes = append(append([]string{}, notifyScopes...), messageScopes...)
+	}
+
+	var targets []targetResult
+	for _, room := range rooms {
+		targets = append(targets, targetResult{Room: room})
+	}
+	for _, user := range users {
+		targets = append(targets, targetResult{User: user})
+	}
+	send := func(t targetResult) targetResult {
+		if t.User != "" {
+			return s.sendUser(t.User, notifications)
+		}
+		return s.sendAll(t.Room, notifications)
+	}
+	if len(targets) == 1 {
+		return send(targets[0]).err
+	}
+
+	parallel := viper.GetInt("parallel")
+	if parallel < 1 {
+		return internal.UsageErrorf("--parallel should be at least 1")
+	}
+	if internal.DryRun() {
+		// Print the requests in a predictable order
+		parallel = 1
+	}
+
+	results := make([]targetResult, len(targets))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t targetResult) {
+			defer wg.Done()
+			results[i] = send(t)
+			<-sem
+		}(i, t)
+	}
+	wg.Wait()
+
+	var failed []targetResult
+	result := internal.Result{Value: results, Header: []string{"TARGET", "STATUS", "ERROR"}}
+	for _, r := range results {
+		result.Rows = append(result.Rows, []string{r.target(), r.Status, r.Error})
+		if r.err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if !internal.DryRun() {
+		if err := internal.PrintResult(result); err != nil {
+			return err
+		}
+	}
+
+	noun := "rooms"
+	switch {
+	case len(rooms) == 0:
+		noun = "users"
+	case len(users) > 0:
+		noun = "rooms and users"
+	}
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(failed) == len(targets):
+		return fmt.Errorf("Could not send to any of the %d %v: %w", len(targets), noun, failed[0].err)
+	default:
+		names := make([]string, len(failed))
+		for i, r := range failed {
+			names[i] = r.target()
+		}
+		return internal.WithExitCode(internal.ExitPartial, fmt.Errorf("Could not send to %d of %d %v: %v", len(failed), len(targets), noun, strings.Join(names, ", ")))
+	}
+}
+
+// notifier sends notifications with a client shared by all targets.
+type notifier struct {
+	// scopes are the scopes the client is requested for.
+	scopes []string
+
+	mu     sync.Mutex
+	client *hipchat.Client
+	err    error
+}
+
+// getClient returns the shared client, creating it on first use.
+func (s *notifier) getClient() (*hipchat.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil && s.err == nil {
+		s.client, s.err = internal.GetClient(s.scopes...)
+	}
+	return s.client, s.err
+}
+
+// sendUser sends the notifications to user as private messages, in order.
+func (s *notifier) sendUser(user string, notifications []*internal.Notification) targetResult {
+	r := targetResult{User: user, Status: statusSent}
+	c, err := s.getClient()
+	if err != nil {
+		r.Status, r.Error, r.err = statusFailed, err.Error(), err
+		return r
+	}
+	for i, n := range notifications {
+		if len(notifications) > 1 {
+			internal.Infof("Sending part %d of %d to user %v", i+1, len(notifications), user)
+		} else {
+			internal.Infof("Sending '%v' to user %v", n.Message, user)
+		}
+
+		if err := deliverMessageWith(c, user, n); err != nil {
+			if len(notifications) > 1 {
+				err = fmt.Errorf("Error while sending part %d of %d: %w", i+1, len(notifications), err)
+			}
+			r.Status, r.Error, r.err = statusFailed, err.Error(), err
+			break
+		}
+	}
+	return r
+}
+
+// sendAll sends the notifications to room in order.
+func (s *notifier) sendAll(room string, notifications []*internal.Notification) targetResult {
+	r := targetResult{Room: room, Status: statusSent}
+	for i, n := range notifications {
+		if r.Status == statusSpooled {
+			// The parts after a spooled part are spooled too, so outbox flush
+			// sends them in order
+			internal.Infof("Storing part %d of %d to %v in the outbox", i+1, len(notifications), room)
+			if _, err := internal.SpoolNotification(room, n, fmt.Errorf("part %d of %d was stored in the outbox", i, len(notifications))); err != nil {
+				err = fmt.Errorf("Error while storing part %d of %d in the outbox: %v", i+1, len(notifications), err)
+				r.Status, r.Error, r.err = statusFailed, err.Error(), err
+				break
+			}
+			continue
+		}
+
+		if len(notifications) > 1 {
+			internal.Infof("Sending part %d of %d to %v", i+1, len(notifications), room)
+		} else {
+			internal.Infof("Sending '%v' to %v", n.Message, room)
+		}
+
+		spooled, err := s.send(room, n)
+		if spooled {
+			r.Status = statusSpooled
+		}
+		if err != nil {
+			if len(notifications) > 1 {
+				err = fmt.Errorf("Error while sending part %d of %d: %w", i+1, len(notifications), err)
+			}
+			r.Status, r.Error, r.err = statusFailed, err.Error(), err
+			break
+		}
+	}
+	return r
+}
+
+// send sends n to room, through the daemon if via_daemon is set and this
+// is not a dry run. If that fails because HipChat cannot be reached and
+// spooling is enabled, the notification is stored in the outbox to be sent
+// by outbox flush.
+func (s *notifier) send(room string, n *internal.Notification) (spooled bool, err error) {
+	if viper.GetBool("via_daemon") && !internal.DryRun() {
+		err := sendViaDaemon(room, n)
+		if _, unreachable := err.(daemonUnreachableError); !unreachable {
+			return false, err
+		}
+		internal.Warnf("%v, sending the notification directly", err)
+	}
+
+	c, err := s.getClient()
+	if err == nil {
+		err = deliverNotificationWith(c, room, n)
+	}
+	if err != nil && spoolFailed(room, n, err) == nil {
+		return true, nil
+	}
+	return false, err
+}
+
+// spoolFailed stores n in the outbox if it could not be sent to room because
+// of the transient error err and spooling is enabled. It returns nil if the
+// notification was stored, err otherwise.
+func spoolFailed(room string, n *internal.Notification, err error) error {
+	if err == nil || !viper.GetBool("spool") || !internal.IsTransient(err) {
+		return err
+	}
+
+	entry, serr := internal.SpoolNotification(room, n, err)
+	if serr != nil {
+		internal.Warnf("Could not store the notification in the outbox: %v", serr)
+		return err
+	}
+	internal.Warnf("Could not send the notification to %v, stored it in the outbox as %v: %v", room, entry.ID, err)
+	return nil
+}
+
+// deliverNotification sends n to room.
+func deliverNotification(room string, n *internal.Notification) error {
+	c, err := internal.GetClient(notifyScopes...)
+	if err != nil {
+		return err
+	}
+	return deliverNotificationWith(c, room, n)
+}
+
+// deliverNotificationWith sends n to room using client c.
+func deliverNotificationWith(c *hipchat.Client, room string, n *internal.Notification) error {
+	req, err := c.NewRequest("POST", fmt.Sprintf("room/%s/notification", room), nil, n)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req, nil)
+	if resp != nil {
+		internal.DebugDump(httputil.DumpResponse(resp, true))
+	}
+	return internal.CheckResponse(resp, err)
+}
+
+// deliverMessageWith sends n to user as a private message using client c.
+// Private messages do not show cards, colors or the from label.
+func deliverMessageWith(c *hipchat.Client, user string, n *internal.Notification) error {
+	m := &hipchat.MessageRequest{Message: n.Message, Notify: n.Notify, MessageFormat: n.MessageFormat}
+	if m.MessageFormat == "" {
+		// Private messages default to text, notifications to html
+		m.MessageFormat = messageFormatHTML
+	}
+	resp, err := c.User.Message(user, m)
+	if resp != nil {
+		internal.DebugDump(httputil.DumpResponse(resp, true))
+	}
+	return userTokenError(internal.CheckResponse(resp, err))
+}
+
+func init() {
+	internal.BindFlag("spool", spoolFlag)
+	internal.BindFlag("via_daemon", viaDaemonFlag)
+	internal.BindFlag("parallel", parallelFlag)
+	viper.SetDefault("parallel", defaultParallel)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/houtmanj/hipchat-cli/internal/fakehipchat"
+	"github.com/spf13/cobra"
+)
+
+// devCmd represents the dev command
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Tools for developing and testing with hipchat-cli",
+}
+
+// fakeServerCmd represents the dev fake-server command
+var fakeServerCmd = &cobra.Command{
+	Use:   "fake-server",
+	Short: "Run a fake HipChat server to test against",
+	Long: `Runs an in-memory fake of the HipChat API v2, to test hipchat-cli and alert pipelines
+without a HipChat server. Point the endpoint at it:
+
+  hipchat-cli dev fake-server --listen 127.0.0.1:8080 &
+  HIPCHAT_ENDPOINT=http://127.0.0.1:8080/v2 hipchat-cli room notify --room ops --message test
+
+The fake accepts any oauthid and oauthsecret, and the personal tokens given with --tokens.
+Access tokens and personal tokens are granted all scopes. It implements the oauth token,
+rooms (get, list, create, update, delete, topic, notification, message, history, webhooks)
+and users. Notifications and messages are kept in the history of the room. Nothing is stored
+when it stops.
+
+Received requests are logged and listed as JSON by GET /_fake/requests.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rooms, _ := cmd.Flags().GetStringSlice("rooms")
+		users, _ := cmd.Flags().GetStringSlice("users")
+		tokens, _ := cmd.Flags().GetStringSlice("tokens")
+
+		s := fakehipchat.New()
+		for _, name := range rooms {
+			s.AddRoom(name, "")
+		}
+		for _, mention := range users {
+			s.AddUser(strings.Title(mention), mention, mention+"@example.com")
+		}
+		for _, token := range tokens {
+			s.AddToken(token, fakehipchat.AllScopes...)
+		}
+		s.Log = func(r fakehipchat.Request) {
+			internal.Infof("%v %v %v", r.Status, r.Method, r.Path)
+			internal.Debugf("Body: %s", r.Body)
+		}
+
+		l, err := net.Listen("tcp", cmd.Flag("listen").Value.String())
+		if err != nil {
+			return internal.UsageErrorf("Error while listening: %v", err)
+		}
+		internal.Infof("Listening on %v, use endpoint http://%v%v", l.Addr(), l.Addr(), strings.TrimSuffix(fakehipchat.Prefix, "/"))
+		fmt.Printf("http://%v%v\n", l.Addr(), strings.TrimSuffix(fakehipchat.Prefix, "/"))
+
+		server := &http.Server{Handler: s}
+		errc := make(chan error, 1)
+		go func() { errc <- server.Serve(l) }()
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case <-signals:
+		case err = <-errc:
+			return err
+		}
+		return server.Shutdown(context.Background())
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(devCmd)
+	devCmd.AddCommand(fakeServerCmd)
+
+	fakeServerCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	fakeServerCmd.Flags().StringSlice("rooms", []string{"ops"}, "Rooms to create")
+	fakeServerCmd.Flags().StringSlice("users", []string{"alice", "bob"}, "Mention names of the users to create")
+	fakeServerCmd.Flags().StringSlice("tokens", nil, "Personal tokens to accept")
+}
@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http/httputil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// viewMessagesScopes are the OAuth scopes needed to read the history of a room.
+var viewMessagesScopes = []string{hipchat.ScopeViewMessages}
+
+const (
+	// historyPageSize is the number of messages requested at once, the maximum allowed by HipChat.
+	historyPageSize = 1000
+	// defaultTailInterval is how often room tail --follow asks for new messages.
+	defaultTailInterval = 5 * time.Second
+)
+
+// historyOptions are the parameters of GET /room/{room}/history, which
+// hipchat.HistoryOptions only partly supports.
+type historyOptions struct {
+	hipchat.ListOptions
+	Date     string `url:"date,omitempty"`
+	EndDate  string `url:"end-date,omitempty"`
+	Timezone string `url:"timezone,omitempty"`
+	// Reverse is always false: only pages that are newest first stay the
+	// same when messages are sent meanwhile.
+	Reverse bool `url:"reverse"`
+}
+
+// fetchHistory passes the messages of room sent between since and until to
+// page, a page at a time, newest first. It stops after max messages unless
+// max is 0. A zero since starts at the first message of the room.
+//
+// Every page is requested up to the date of the oldest message of the page
+// before, rather than at an offset, so messages deleted or sent meanwhile
+// do not make it skip messages.
+func fetchHistory(c *hipchat.Client, room string, since, until time.Time, timezone string, max int, page func([]internal.HistoryMessage) error) error {
+	opt := &historyOptions{
+		ListOptions: hipchat.ListOptions{MaxResults: historyPageSize},
+		Date:        until.Format(time.RFC3339Nano),
+		Timezone:    timezone,
+	}
+	if !since.IsZero() {
+		opt.EndDate = since.Format(time.RFC3339Nano)
+	}
+
+	// seen holds the messages sent at opt.Date that were passed already, as
+	// the next page starts with them again
+	seen := map[string]bool{}
+	count := 0
+	for {
+		req, err := c.NewRequest("GET", fmt.Sprintf("room/%s/history", room), opt, nil)
+		if err != nil {
+			return err
+		}
+		history := &internal.HistoryPage{}
+		resp, err := c.Do(req, history)
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return internal.CheckResponse(resp, err)
+		}
+
+		items := []internal.HistoryMessage{}
+		for _, m := range history.Items {
+			if !seen[m.ID] && (max <= 0 || count < max) {
+				items = append(items, m)
+				count++
+			}
+		}
+		internal.Debugf("Read %d messages of room %v", count, room)
+		if err := page(items); err != nil {
+			return err
+		}
+
+		if len(history.Items) < opt.MaxResults || history.Links.Next == "" || (max > 0 && count >= max) {
+			return nil
+		}
+		oldest := history.Items[len(history.Items)-1].Date
+		if oldest != opt.Date {
+			opt.Date, opt.StartIndex = oldest, 0
+			seen = map[string]bool{}
+		} else {
+			// A whole page was sent at the same time, which a date cannot skip
+			opt.StartIndex += len(history.Items)
+		}
+		for _, m := range history.Items {
+			if m.Date == oldest {
+				seen[m.ID] = true
+			}
+		}
+	}
+}
+
+// historyFlags are the settings of the history commands.
+type historyFlags struct {
+	loc      *time.Location
+	timezone string
+	format   string
+}
+
+// parseHistoryFlags reads the --timezone and --output flags of cmd.
+func parseHistoryFlags(cmd *cobra.Command) (*historyFlags, error) {
+	f := &historyFlags{loc: time.Local, format: cmd.Flag("output").Value.String()}
+	if cmd.Flag("format").Changed && !cmd.Flag("output").Changed {
+		f.format = cmd.Flag("format").Value.String()
+	}
+	if f.timezone = cmd.Flag("timezone").Value.String(); f.timezone != "" {
+		loc, err := time.LoadLocation(f.timezone)
+		if err != nil {
+			return nil, internal.UsageErrorf("invalid --timezone %v, use a name like Europe/Amsterdam", f.timezone)
+		}
+		f.loc = loc
+	}
+	for _, format := range internal.HistoryFormats {
+		if f.format == format {
+			return f, nil
+		}
+	}
+	return nil, internal.UsageErrorf("invalid --output %v, use %v", f.format, strings.Join(internal.HistoryFormats, ", "))
+}
+
+// roomHistoryCmd represents the room history command
+var roomHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the history of a room",
+	Long: `Shows the messages sent to a room, newest first unless --reverse is given. All
+messages between --since and --until are read, a page at a time.
+
+--since and --until are a date like 2017-01-02, a time like "2017-01-02 15:04",
+or a duration like 24h before now. They are in --timezone unless they include
+a zone, which is also used to show times.
+
+--output selects how the messages are written. The history is a stream of
+messages rather than a single result, so it has formats of its own and the
+output setting of the config file does not apply:
+text:   a transcript, a line per message
+ndjson: a JSON object per line, with the files and cards of the messages
+csv:    a row per message, with a header
+mbox:   a mail per message, to read the history with a mail client
+
+HipChat returns the newest messages first, so --reverse keeps all messages in
+memory until the oldest is read. Limit it with --since or --max for busy rooms.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		room, err := singleRoom()
+		if err != nil {
+			return err
+		}
+		f, err := parseHistoryFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		since, until := time.Time{}, now
+		if v := cmd.Flag("since").Value.String(); v != "" {
+			if since, err = internal.ParseHistoryTime(v, f.loc, now); err != nil {
+				return internal.UsageErrorf("invalid --since: %v", err)
+			}
+		}
+		if v := cmd.Flag("until").Value.String(); v != "" {
+			if until, err = internal.ParseHistoryTime(v, f.loc, now); err != nil {
+				return internal.UsageErrorf("invalid --until: %v", err)
+			}
+		}
+		if until.Before(since) {
+			return internal.UsageErrorf("--until is before --since")
+		}
+		max, _ := cmd.Flags().GetInt("max")
+		reverse, _ := cmd.Flags().GetBool("reverse")
+
+		c, err := internal.GetClient(viewMessagesScopes...)
+		if err != nil {
+			return err
+		}
+		w, err := internal.NewHistoryWriter(os.Stdout, f.format, room, f.loc)
+		if err != nil {
+			return err
+		}
+
+		// Oldest first needs all messages, HipChat pages newest first
+		var all []internal.HistoryMessage
+		err = fetchHistory(c, room, since, until, f.timezone, max, func(page []internal.HistoryMessage) error {
+			if reverse {
+				all = append(all, page...)
+				return nil
+			}
+			for i := range page {
+				if err := w.Write(&page[i]); err != nil {
+					return err
+				}
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			return err
+		}
+		for i := len(all) - 1; i >= 0; i-- {
+			if err := w.Write(&all[i]); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	},
+}
+
+// roomTailCmd represents the room tail command
+var roomTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Show the latest messages of a room",
+	Long: `Shows the latest messages of a room, oldest first. With --follow new messages
+are shown as they are sent, until interrupted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		room, err := singleRoom()
+		if err != nil {
+			return err
+		}
+		f, err := parseHistoryFlags(cmd)
+		if err != nil {
+			return err
+		}
+		max, _ := cmd.Flags().GetInt("max")
+		if max < 1 || max > historyPageSize {
+			return internal.UsageErrorf("--max should be between 1 and %d", historyPageSize)
+		}
+		follow, _ := cmd.Flags().GetBool("follow")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval <= 0 {
+			return internal.UsageErrorf("--interval should be a duration like 5s")
+		}
+
+		c, err := internal.GetClient(viewMessagesScopes...)
+		if err != nil {
+			return err
+		}
+		w, err := internal.NewHistoryWriter(os.Stdout, f.format, room, f.loc)
+		if err != nil {
+			return err
+		}
+
+		opt := &hipchat.LatestHistoryOptions{MaxResults: max, Timezone: f.timezone}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		for {
+			messages, err := latestHistory(c, room, opt)
+			if err != nil {
+				return err
+			}
+			for i := range messages {
+				if err := w.Write(&messages[i]); err != nil {
+					return err
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if !follow {
+				return nil
+			}
+
+			select {
+			case <-signals:
+				return nil
+			case <-time.After(interval):
+			}
+			opt.MaxResults = historyPageSize
+		}
+	},
+}
+
+// latestHistory returns the latest messages of room, oldest first. When
+// opt.NotBefore is set only the messages after it are returned, and it is
+// updated to the last message.
+func latestHistory(c *hipchat.Client, room string, opt *hipchat.LatestHistoryOptions) ([]internal.HistoryMessage, error) {
+	req, err := c.NewRequest("GET", fmt.Sprintf("room/%s/history/latest", room), opt, nil)
+	if err != nil {
+		return nil, err
+	}
+	history := &internal.HistoryPage{}
+	resp, err := c.Do(req, history)
+	if err != nil {
+		if resp != nil {
+			internal.DebugDump(httputil.DumpResponse(resp, true))
+		}
+		return nil, internal.CheckResponse(resp, err)
+	}
+
+	messages := history.Items
+	if opt.NotBefore != "" {
+		// The first message is the last one already shown
+		found := false
+		for i, m := range messages {
+			if m.ID == opt.NotBefore {
+				messages, found = messages[i+1:], true
+				break
+			}
+		}
+		if !found {
+			internal.Warnf("Message %v is no longer in the latest messages of room %v, messages may be missing", opt.NotBefore, room)
+		}
+	}
+	if len(messages) > 0 {
+		opt.NotBefore = messages[len(messages)-1].ID
+	}
+	return messages, nil
+}
+
+// addHistoryFlags adds the flags read by parseHistoryFlags to cmd. Its
+// --output replaces the global one, which has no history formats.
+func addHistoryFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", internal.HistoryText, "Format of the messages: "+strings.Join(internal.HistoryFormats, ", "))
+	cmd.Flags().String("format", internal.HistoryText, "Format of the messages")
+	cmd.Flags().MarkDeprecated("format", "use --output instead")
+	cmd.Flags().String("timezone", "", "Time zone of the times, like Europe/Amsterdam (default the local time zone)")
+}
+
+func init() {
+	roomCmd.AddCommand(roomHistoryCmd)
+	roomCmd.AddCommand(roomTailCmd)
+
+	requiresScopes(roomHistoryCmd, viewMessagesScopes...)
+	requiresScopes(roomTailCmd, viewMessagesScopes...)
+
+	addHistoryFlags(roomHistoryCmd)
+	roomHistoryCmd.Flags().String("since", "", "Show the messages sent since this time")
+	roomHistoryCmd.Flags().String("until", "", "Show the messages sent until this time (default now)")
+	roomHistoryCmd.Flags().Int("max", 0, "Maximum number of messages to show, the newest (default all)")
+	roomHistoryCmd.Flags().Bool("reverse", false, "Show the oldest message first, after reading all messages")
+
+	addHistoryFlags(roomTailCmd)
+	roomTailCmd.Flags().BoolP("follow", "f", false, "Keep showing new messages")
+	roomTailCmd.Flags().Int("max", 10, "Number of latest messages to show")
+	roomTailCmd.Flags().Duration("interval", defaultTailInterval, "How often to ask for new messages with --follow")
+}
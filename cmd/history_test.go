@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+)
+
+func TestRoomHistory(t *testing.T) {
+	fake, stop := startFake("ops")
+	defer stop()
+
+	// More messages than fit in two pages, sent in pairs at the same time so
+	// pages also end between messages with the same date
+	const count = 2500
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < count; i++ {
+		if err := fake.AddMessage("ops", "alice", strconv.Itoa(i), start.Add(time.Duration(i/2)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+		// first and last are the first and last message shown
+		first, last, messages int
+	}{
+		{"all", nil, internal.ExitOK, count - 1, 0, count},
+		{"reverse", []string{"--reverse"}, internal.ExitOK, 0, count - 1, count},
+		{"max", []string{"--max", "1500"}, internal.ExitOK, count - 1, count - 1500, 1500},
+		{"reverse max", []string{"--reverse", "--max", "10"}, internal.ExitOK, count - 10, count - 1, 10},
+		{"since", []string{"--since", start.Add(1000 * time.Millisecond).Format(time.RFC3339Nano)}, internal.ExitOK, count - 1, 2000, 500},
+		{"until", []string{"--until", start.Add(999 * time.Millisecond).Format(time.RFC3339Nano)}, internal.ExitOK, 1999, 0, 2000},
+		{"until before since", []string{"--since", "1h", "--until", "2h"}, internal.ExitUsage, 0, 0, 0},
+		{"invalid since", []string{"--since", "yesterday"}, internal.ExitUsage, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"room", "history", "--room", "ops", "--output", "ndjson"}, tt.args...)
+			out, err := run(t, args...)
+			checkExitCode(t, err, tt.code)
+			if tt.code != internal.ExitOK {
+				return
+			}
+
+			var messages []string
+			seen := map[string]bool{}
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				var m internal.HistoryMessage
+				if err := json.Unmarshal([]byte(line), &m); err != nil {
+					t.Fatalf("invalid line %q: %v", line, err)
+				}
+				if seen[m.ID] {
+					t.Fatalf("message %v is shown twice", m.Message)
+				}
+				seen[m.ID] = true
+				messages = append(messages, m.Message)
+			}
+			if len(messages) != tt.messages {
+				t.Fatalf("got %d messages, want %d", len(messages), tt.messages)
+			}
+			if first, last := messages[0], messages[len(messages)-1]; first != strconv.Itoa(tt.first) || last != strconv.Itoa(tt.last) {
+				t.Errorf("got messages %v to %v, want %v to %v", first, last, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestRoomHistoryNotFound(t *testing.T) {
+	_, stop := startFake("ops")
+	defer stop()
+
+	_, err := run(t, "room", "history", "--room", "dev")
+	checkExitCode(t, err, internal.ExitNotFound)
+}
+
+func TestRoomHistoryOutput(t *testing.T) {
+	fake, stop := startFake("ops")
+	defer stop()
+	if err := fake.AddMessage("ops", "alice", "deployed", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"output", []string{"--output", "ndjson"}, internal.ExitOK},
+		{"shorthand", []string{"-o", "ndjson"}, internal.ExitOK},
+		{"deprecated format", []string{"--format", "ndjson"}, internal.ExitOK},
+		{"global format", []string{"--output", "json"}, internal.ExitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := run(t, append([]string{"room", "history", "--room", "ops"}, tt.args...)...)
+			checkExitCode(t, err, tt.code)
+			if tt.code != internal.ExitOK {
+				return
+			}
+			var m internal.HistoryMessage
+			if err := json.Unmarshal([]byte(out), &m); err != nil || m.Message != "deployed" {
+				t.Errorf("output = %q, want the message as JSON", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// maxRoomMessageLength is the maximum length of a message posted by a user.
+const maxRoomMessageLength = 1000
+
+// messageScopes are the OAuth scopes needed to post a message, and to look
+// up the users it mentions.
+var (
+	messageScopes = []string{hipchat.ScopeSendMessage}
+	mentionScopes = []string{hipchat.ScopeViewGroup}
+)
+
+// mentionPattern matches a mention of a user by ID or email, like @{alice@example.com}.
+var mentionPattern = regexp.MustCompile(`@\{([^{}\s]+)\}`)
+
+// roomMessageCmd represents the room message command
+var roomMessageCmd = &cobra.Command{
+	Use:   "message",
+	Short: "Posts a message to a room as a user",
+	Long: `Posts a plain text message to a room as the user of the token, unlike notify which
+sends a notification that is shown as sent by the integration.
+
+This needs a personal access token with the send_message scope in the token key of the
+config file. Room tokens and the oauthid and oauthsecret of an add-on can only send
+notifications.
+
+The message is given with --message, or read from a file with --message-file. Use - to
+read it from stdin. --tail only posts the last lines of the message. Messages longer than
+HipChat's limit of 1000 characters are split into multiple messages, posted in order.
+
+Users are mentioned with @mentionname, or by ID or email with @{alice@example.com}, which
+is replaced by their @mention name. --mention adds mentions in front of the message:
+
+  hipchat-cli room message --room ops --message "deploy is done" --mention alice@example.com
+
+Looking up users by ID or email requires the view_group scope.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		room, err := singleRoom()
+		if err != nil {
+			return err
+		}
+		message, err := notifyMessage(cmd, nil, nil)
+		if err != nil {
+			return err
+		}
+		mentions, _ := cmd.Flags().GetStringSlice("mention")
+		for i := len(mentions) - 1; i >= 0; i-- {
+			if strings.HasPrefix(mentions[i], "@") {
+				message = mentions[i] + " " + message
+			} else {
+				message = "@{" + mentions[i] + "} " + message
+			}
+		}
+
+		// An add-on cannot post messages, so do not request an access token for it
+		mode, err := internal.ConfiguredAuthMode()
+		if err != nil {
+			return err
+		}
+		if mode == internal.AuthModeOAuth {
+			return internal.WithExitCode(internal.ExitAuth, fmt.Errorf("Posting a message as a user needs a personal access token in the token key of the config file, "+
+				"the oauthid and oauthsecret of an add-on can only send notifications, use room notify"))
+		}
+
+		scopes := messageScopes
+		if mentionPattern.MatchString(message) {
+			scopes = append(append([]string{}, messageScopes...), mentionScopes...)
+		}
+		c, err := internal.GetClient(scopes...)
+		if err != nil {
+			return err
+		}
+
+		if message, err = expandMentions(c, message); err != nil {
+			return err
+		}
+
+		for _, part := range internal.SplitMessage(message, maxRoomMessageLength) {
+			internal.Infof("Posting message to room %v", room)
+			resp, err := c.Room.Message(room, &hipchat.RoomMessageRequest{Message: part})
+			if err != nil {
+				if resp != nil {
+					internal.DebugDump(httputil.DumpResponse(resp, true))
+				}
+				return userTokenError(internal.CheckResponse(resp, err))
+			}
+		}
+		return nil
+	},
+}
+
+// expandMentions replaces the mentions of users by ID or email in message
+// by their @mention names.
+func expandMentions(c *hipchat.Client, message string) (string, error) {
+	if internal.DryRun() {
+		// Users cannot be looked up without sending a request
+		return message, nil
+	}
+
+	names := map[string]string{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(message, -1) {
+		id := m[1]
+		if _, found := names[id]; found {
+			continue
+		}
+		u, resp, err := c.User.View(id)
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return "", fmt.Errorf("Error while looking up user %v: %w", id, internal.CheckResponse(resp, err))
+		}
+		names[id] = "@" + u.MentionName
+	}
+	return mentionPattern.ReplaceAllStringFunc(message, func(m string) string {
+		return names[mentionPattern.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+// userTokenError explains that a token that was refused may not be the
+// token of a user.
+func userTokenError(err error) error {
+	var apiErr *internal.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		apiErr.Hint = "Posting a message needs the personal access token of a user with the send_message scope, room tokens can only send notifications"
+	}
+	return err
+}
+
+func init() {
+	roomCmd.AddCommand(roomMessageCmd)
+
+	roomMessageCmd.Flags().String("message", "", "Message to post, - reads it from stdin")
+	roomMessageCmd.Flags().String("message-file", "", "File to read the message from, - reads it from stdin")
+	roomMessageCmd.Flags().Int("tail", 0, "Only post the last N lines of the message")
+	roomMessageCmd.Flags().StringSlice("mention", nil, "ID, email or @mention name of a user to mention, can be repeated")
+}
@@ -2,12 +2,11 @@ package cmd
 
 import (
 	"fmt"
-	"net/http/httputil"
 	"strings"
 
 	"github.com/houtmanj/hipchat-cli/internal"
-	"github.com/nu7hatch/gouuid"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/tbruyelle/hipchat-go/hipchat"
 )
 
@@ -64,36 +63,32 @@ Example:
 hipchat-cli nagios --room production  --type service --status critical --service "Apache process" --output "ok - pid found" \
   --host main-web-100 --monitorurl https://nagios.com/dashboard/ \
   --actions "CreateTicket:http://jira.com"  --actions "Ack:http://nagios.com?a=ack&alert=x"
-`,
+
+--user also sends the alert as a private message to users, see: hipchat-cli room notify --help`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		notif, err := validateArguments(cmd)
 		if err != nil {
 			return err
 		}
 
-		c, err := internal.GetClient()
-		if err != nil {
-			return err
-		}
-
 		n, err := getNotification(notif)
 		if err != nil {
-			return fmt.Errorf("error while compiling notification: %v", err)
+			return fmt.Errorf("error while compiling notification: %w", err)
 		}
 
-		room := cmd.Flag("room").Value.String()
-
-		resp, err := c.Room.Notification(room, n)
-		if resp != nil {
-			internal.Debug(httputil.DumpResponse(resp, true))
+		rooms, users, err := targets(cmd)
+		if err != nil {
+			return err
 		}
-		return err
+		return broadcast(rooms, users, []*internal.Notification{{NotificationRequest: *n}})
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(nagiosCmd)
 
+	requiresScopes(nagiosCmd, notifyScopes...)
+
 	nagiosCmd.Flags().String("type", "service", "monitoring check type: host or service")
 	nagiosCmd.Flags().String("status", "", "check status: CRITICAL, WARNING, UNKNOWN, OK")
 
@@ -102,46 +97,74 @@ func init() {
 	nagiosCmd.Flags().String("output", "", "check output")
 	nagiosCmd.Flags().String("monitorurl", "", "Url to monitoring page")
 	nagiosCmd.Flags().StringSlice("actions", []string{}, "actions to put in the notification format:  <name>:<link>")
-	nagiosCmd.Flags().String("room", "", "Name of the room")
+	nagiosCmd.Flags().AddFlag(roomFlag)
+	nagiosCmd.Flags().AddFlag(userFlag)
+	nagiosCmd.Flags().AddFlag(spoolFlag)
+	nagiosCmd.Flags().AddFlag(viaDaemonFlag)
+	nagiosCmd.Flags().AddFlag(parallelFlag)
 
 	nagiosCmd.Flags().Bool("notify", false, "Send out notification to clients")
 }
 
-func validateArguments(cmd *cobra.Command) (nagiosNotification, error) {
+// nagiosInput are the arguments of a nagios alert, given as flags or
+// in the payload sent to the daemon.
+type nagiosInput struct {
+	Room       string   `json:"room"`
+	Type       string   `json:"type"`
+	Status     string   `json:"status"`
+	Service    string   `json:"service"`
+	Host       string   `json:"host"`
+	Output     string   `json:"output"`
+	MonitorURL string   `json:"monitorurl"`
+	Actions    []string `json:"actions"`
+	Notify     bool     `json:"notify"`
+}
 
-	if cmd.Flag("room").Value.String() == "" {
-		return nagiosNotification{}, fmt.Errorf("--room <room> is mandatory")
+func validateArguments(cmd *cobra.Command) (nagiosNotification, error) {
+	actionsSlice, err := cmd.Flags().GetStringSlice("actions")
+	if err != nil {
+		return nagiosNotification{}, err
 	}
 
-	t, err := validateCheckType(cmd.Flag("type").Value.String())
+	return parseNagiosInput(nagiosInput{
+		Room:       viper.GetString("room"),
+		Type:       cmd.Flag("type").Value.String(),
+		Status:     cmd.Flag("status").Value.String(),
+		Service:    cmd.Flag("service").Value.String(),
+		Host:       cmd.Flag("host").Value.String(),
+		Output:     cmd.Flag("output").Value.String(),
+		MonitorURL: cmd.Flag("monitorurl").Value.String(),
+		Actions:    actionsSlice,
+		Notify:     cmd.Flag("notify").Changed,
+	})
+}
+
+func parseNagiosInput(in nagiosInput) (nagiosNotification, error) {
+	t, err := validateCheckType(in.Type)
 	if err != nil {
 		return nagiosNotification{}, err
 	}
 
-	status, err := validateStatus(cmd.Flag("status").Value.String())
+	status, err := validateStatus(in.Status)
 	if err != nil {
 		return nagiosNotification{}, err
 	}
 
 	if t == serviceType {
-		if cmd.Flag("service").Value.String() == "" {
-			return nagiosNotification{}, fmt.Errorf("--service is mandatory")
+		if in.Service == "" {
+			return nagiosNotification{}, internal.UsageErrorf("--service is mandatory")
 		}
 	}
 
-	if cmd.Flag("host").Value.String() == "" {
-		return nagiosNotification{}, fmt.Errorf("--host is mandatory")
+	if in.Host == "" {
+		return nagiosNotification{}, internal.UsageErrorf("--host is mandatory")
 	}
 
-	if cmd.Flag("output").Value.String() == "" {
-		return nagiosNotification{}, fmt.Errorf("--output is mandatory")
+	if in.Output == "" {
+		return nagiosNotification{}, internal.UsageErrorf("--output is mandatory")
 	}
 
-	actionsSlice, err := cmd.Flags().GetStringSlice("actions")
-	if err != nil {
-		return nagiosNotification{}, err
-	}
-	actions, err := validateActions(actionsSlice)
+	actions, err := validateActions(in.Actions)
 	if err != nil {
 		return nagiosNotification{}, err
 	}
@@ -149,11 +172,11 @@ func validateArguments(cmd *cobra.Command) (nagiosNotification, error) {
 	notif := nagiosNotification{
 		CheckType:  t,
 		Status:     status,
-		Service:    cmd.Flag("service").Value.String(),
-		Host:       cmd.Flag("host").Value.String(),
-		Output:     cmd.Flag("output").Value.String(),
-		MonitorURL: cmd.Flag("monitorurl").Value.String(),
-		Notify:     cmd.Flag("notify").Changed,
+		Service:    in.Service,
+		Host:       in.Host,
+		Output:     in.Output,
+		MonitorURL: in.MonitorURL,
+		Notify:     in.Notify,
 		Actions:    actions,
 	}
 
@@ -169,7 +192,7 @@ func validateCheckType(checktype string) (nagiosType, error) {
 	case "host":
 		return typeHost, nil
 	default:
-		return typeInvalid, fmt.Errorf("invalid --check_type, should be service or host")
+		return typeInvalid, internal.UsageErrorf("invalid --check_type, should be service or host")
 	}
 }
 
@@ -192,7 +215,7 @@ func validateStatus(status string) (nagiosStatus, error) {
 	case statusUnreachable.str:
 		return statusUnreachable, nil
 	default:
-		return statusInvalid, fmt.Errorf("invalid --status, should be critical, warning, unknown or ok")
+		return statusInvalid, internal.UsageErrorf("invalid --status, should be critical, warning, unknown or ok")
 	}
 }
 
@@ -200,7 +223,7 @@ func validateActions(actionSlice []string) (actions []nagiosActions, err error)
 	for _, v := range actionSlice {
 		splits := strings.SplitN(v, ":", 2)
 		if len(splits) != 2 {
-			return actions, fmt.Errorf("--actions format is <action>:<URL>")
+			return actions, internal.UsageErrorf("--actions format is <action>:<URL>")
 		}
 		actions = append(actions, nagiosActions{splits[0], splits[1]})
 	}
@@ -208,11 +231,6 @@ func validateActions(actionSlice []string) (actions []nagiosActions, err error)
 }
 
 func getNotification(notif nagiosNotification) (*hipchat.NotificationRequest, error) {
-	uid, err := uuid.NewV4()
-	if err != nil {
-		return nil, err
-	}
-
 	n := hipchat.NotificationRequest{
 		Message: getMessage(notif),
 		Notify:  notif.Notify,
@@ -222,7 +240,6 @@ func getNotification(notif nagiosNotification) (*hipchat.NotificationRequest, er
 			URL:         notif.MonitorURL,
 			Format:      "medium",
 			Title:       getTitle(notif),
-			ID:          uid.String(),
 			Description: hipchat.CardDescription{Format: "html", Value: notif.Output},
 			Icon: &hipchat.Icon{
 				URL: "https://a.fsdn.com/allura/p/nagiosplug/icon",
@@ -231,6 +248,12 @@ func getNotification(notif nagiosNotification) (*hipchat.NotificationRequest, er
 			Activity:   getActivity(notif),
 		},
 	}
+
+	uid, err := cardID(n)
+	if err != nil {
+		return nil, err
+	}
+	n.Card.ID = uid.String()
 	return &n, nil
 }
 
@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"fmt"
-
-	"net/http/httputil"
+	"sort"
+	"strings"
 
 	"github.com/houtmanj/hipchat-cli/internal"
 	"github.com/tbruyelle/hipchat-go/hipchat"
@@ -13,37 +13,281 @@ import (
 
 var notify bool
 
+// Message formats of notifications.
+const (
+	messageFormatHTML = "html"
+	messageFormatText = "text"
+)
+
+// maxFromLength is the maximum length of the from label of a notification.
+const maxFromLength = 64
+
+// colors are the colors of notifications.
+var colors = []hipchat.Color{hipchat.ColorYellow, hipchat.ColorGreen, hipchat.ColorRed, hipchat.ColorPurple, hipchat.ColorGray, hipchat.ColorRandom}
+
+// notifyScopes are the OAuth scopes needed to send a notification.
+var notifyScopes = []string{hipchat.ScopeSendNotification}
+
 // notifyCmd represents the notify command
 var notifyCmd = &cobra.Command{
 	Use:   "notify",
 	Short: "Sends a notification to a room",
-	Long:  `Use the --notify to indicate if the room members should receive a notification`,
+	Long: `Use the --notify to indicate if the room members should receive a notification
+
+The message is given with --message, or read from a file with --message-file.
+Use - to read it from stdin, for example: df -h | hipchat-cli room notify --room ops --message -
+--tail only sends the last lines of the message. Messages longer than HipChat's limit of
+10000 characters are split into multiple notifications, sent in order. HTML messages are
+split between tags, never inside a tag or an entity.
+
+--room can be repeated or a comma separated list of rooms and groups of rooms from the
+groups key of the config file. --user sends the message as a private message to users,
+given by ID, email or @mention name, which needs a personal access token with the
+send_message scope. The rooms and users are sent to in parallel, see --parallel, and a
+summary of the results is shown. Cards, colors and the from label are not shown in private
+messages, and --spool and --via-daemon only apply to rooms.
+
+A card is sent along with the message with --card and the --card-* and --attribute flags,
+or read from a JSON or YAML file with --card-file. Without --message the title of the card
+is used as message, it is shown by clients that do not support cards:
+
+  hipchat-cli room notify --room ops --card --card-title "Deployed api 1.2" \
+    --card-url https://ci.example.com/42 --attribute env=prod,style=lozenge-success
+
+Messages can be rendered from a template with --template, defined in the templates key of
+the config file or a file in the templates directory, filled in with --var:
+
+  hipchat-cli room notify --room ops --template deploy --var app=api --var version=1.2
+
+See the README for the definition of templates. The flags override the color, format, from
+and card of the template.
+
+Messages are HTML unless --format text is used. To include untrusted text in an HTML message,
+--escape shows the whole message as is, and --sanitize removes the tags and links HipChat does
+not render or that are unsafe.
+
+--attach-to attaches the notification to an earlier message of the room, given by its ID as
+shown by room history --output ndjson.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if !cmd.Flag("message").Changed {
-			return fmt.Errorf("no message specified, use --message")
+		base, err := renderTemplate(cmd)
+		if err != nil {
+			return err
 		}
-		room := cmd.Flag("room").Value.String()
-		message := cmd.Flag("message").Value.String()
-
-		c, err := internal.GetClient()
+		card, err := buildCard(cmd)
+		if err != nil {
+			return err
+		}
+		if card == nil && base != nil && base.Card != nil {
+			if card, err = completeCard(base.Card); err != nil {
+				return err
+			}
+		}
+		message, err := notifyMessage(cmd, base, card)
+		if err != nil {
+			return err
+		}
+		template, err := notificationOptions(cmd, base)
+		if err != nil {
+			return err
+		}
+		escape, _ := cmd.Flags().GetBool("escape")
+		sanitize, _ := cmd.Flags().GetBool("sanitize")
+		switch {
+		case (escape || sanitize) && template.MessageFormat == messageFormatText:
+			return internal.UsageErrorf("--escape and --sanitize only apply to --format html")
+		case escape && sanitize:
+			return internal.UsageErrorf("use either --escape or --sanitize")
+		case escape:
+			message = internal.EscapeHTML(message)
+		case sanitize:
+			message = internal.SanitizeHTML(message)
+		}
+		rooms, users, err := targets(cmd)
 		if err != nil {
 			return err
 		}
 
-		cmd.Printf("Sending '%v' to %v\n", message, room)
-
-		resp, err := c.Room.Notification(room, &hipchat.NotificationRequest{Message: message, Notify: notify})
-		if resp != nil {
-			internal.Debug(httputil.DumpResponse(resp, true))
+		// HipChat defaults to html
+		parts := internal.SplitHTMLMessage(message, internal.MaxMessageLength)
+		if template.MessageFormat == messageFormatText {
+			parts = internal.SplitMessage(message, internal.MaxMessageLength)
+		}
+		var notifications []*internal.Notification
+		for i, part := range parts {
+			n := &internal.Notification{NotificationRequest: template, AttachTo: cmd.Flag("attach-to").Value.String()}
+			n.Message = part
+			if i == 0 {
+				n.Card = card
+			}
+			notifications = append(notifications, n)
 		}
-		return err
+		return broadcast(rooms, users, notifications)
 	},
 }
 
+// renderTemplate returns the notification rendered from --template with the
+// --var variables, or nil if no template is given.
+func renderTemplate(cmd *cobra.Command) (*hipchat.NotificationRequest, error) {
+	vars := *cmd.Flag("var").Value.(*varFlag)
+	name := cmd.Flag("template").Value.String()
+	if name == "" {
+		if len(vars) > 0 {
+			return nil, internal.UsageErrorf("--var is only used with --template")
+		}
+		return nil, nil
+	}
+	if cmd.Flag("message").Changed || cmd.Flag("message-file").Changed {
+		return nil, internal.UsageErrorf("the message is rendered from --template, do not use --message or --message-file")
+	}
+
+	t, err := internal.LoadTemplate(name)
+	if err != nil {
+		return nil, internal.WithExitCode(internal.ExitUsage, err)
+	}
+	n, err := t.Render(vars, false)
+	if err != nil {
+		return nil, internal.UsageErrorf("Error while rendering template %v: %v", name, err)
+	}
+	return n, nil
+}
+
+// notifyMessage returns the message given by --message, --message-file or
+// the template, limited to the lines given by --tail. Without either the
+// title of card is used, if any.
+func notifyMessage(cmd *cobra.Command, base *hipchat.NotificationRequest, card *hipchat.Card) (string, error) {
+	message := cmd.Flag("message").Value.String()
+	file := cmd.Flag("message-file").Value.String()
+	switch {
+	case cmd.Flag("message").Changed && cmd.Flag("message-file").Changed:
+		return "", internal.UsageErrorf("use either --message or --message-file")
+	case base != nil:
+		message = base.Message
+	case cmd.Flag("message-file").Changed:
+	case message == "-":
+		file = "-"
+	case !cmd.Flag("message").Changed && card != nil:
+		message = card.Title
+	case !cmd.Flag("message").Changed:
+		return "", internal.UsageErrorf("no message specified, use --message or --message-file")
+	}
+
+	if file != "" {
+		var err error
+		if message, err = internal.ReadMessage(file); err != nil {
+			return "", err
+		}
+	}
+
+	if cmd.Flag("tail").Changed {
+		tail, _ := cmd.Flags().GetInt("tail")
+		if tail < 1 {
+			return "", internal.UsageErrorf("--tail should be at least 1")
+		}
+		message = internal.TailLines(message, tail)
+	}
+
+	if strings.TrimSpace(message) == "" {
+		return "", internal.UsageErrorf("the message is empty")
+	}
+	return message, nil
+}
+
+// notificationOptions returns a notification with the format, color, from
+// label and notify setting of base, if any, overridden by the flags.
+func notificationOptions(cmd *cobra.Command, base *hipchat.NotificationRequest) (hipchat.NotificationRequest, error) {
+	n := hipchat.NotificationRequest{Notify: notify}
+	if base != nil {
+		n = hipchat.NotificationRequest{MessageFormat: base.MessageFormat, Color: base.Color, From: base.From, Notify: base.Notify}
+	}
+	if cmd.Flag("notify").Changed {
+		n.Notify = notify
+	}
+	if cmd.Flag("format").Changed {
+		n.MessageFormat = cmd.Flag("format").Value.String()
+	}
+	if cmd.Flag("color").Changed {
+		n.Color = hipchat.Color(cmd.Flag("color").Value.String())
+	}
+	if cmd.Flag("from").Changed {
+		n.From = cmd.Flag("from").Value.String()
+	}
+
+	if n.MessageFormat != "" && n.MessageFormat != messageFormatHTML && n.MessageFormat != messageFormatText {
+		return n, internal.UsageErrorf("invalid format %v, use html or text", n.MessageFormat)
+	}
+	color, err := parseColor(string(n.Color))
+	if err != nil {
+		return n, err
+	}
+	n.Color = color
+	if len([]rune(n.From)) > maxFromLength {
+		return n, internal.UsageErrorf("--from should be at most %d characters", maxFromLength)
+	}
+	return n, nil
+}
+
+// varFlag is a repeatable flag of template variables, given as name=value.
+type varFlag map[string]string
+
+func (f *varFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for name, value := range *f {
+		names = append(names, name+"="+value)
+	}
+	sort.Strings(names)
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func (f *varFlag) Type() string {
+	return "name=value"
+}
+
+func (f *varFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("should be name=value")
+	}
+	(*f)[kv[0]] = kv[1]
+	return nil
+}
+
+// parseColor validates the color of a notification, "" leaves it to HipChat.
+func parseColor(color string) (hipchat.Color, error) {
+	if color == "" {
+		return "", nil
+	}
+	for _, c := range colors {
+		if hipchat.Color(color) == c {
+			return c, nil
+		}
+	}
+	names := make([]string, len(colors))
+	for i, c := range colors {
+		names[i] = string(c)
+	}
+	return "", internal.UsageErrorf("invalid color %v, use one of: %v", color, strings.Join(names, ", "))
+}
+
 func init() {
 	roomCmd.AddCommand(notifyCmd)
 
-	notifyCmd.Flags().String("message", "", "Message to send")
-	notifyCmd.Flags().BoolVar(&notify, "notify", false, "Send out notification to clients")
+	requiresScopes(notifyCmd, notifyScopes...)
 
+	notifyCmd.Flags().String("message", "", "Message to send, - reads it from stdin")
+	notifyCmd.Flags().String("message-file", "", "File to read the message from, - reads it from stdin")
+	notifyCmd.Flags().Int("tail", 0, "Only send the last N lines of the message")
+	notifyCmd.Flags().BoolVar(&notify, "notify", false, "Send out notification to clients")
+	notifyCmd.Flags().String("format", "", "Format of the message: html or text (default html)")
+	notifyCmd.Flags().String("color", "", "Background color of the message: yellow, green, red, purple, gray or random (default yellow)")
+	notifyCmd.Flags().String("from", "", "Label shown next to the name of the sender")
+	notifyCmd.Flags().String("attach-to", "", "ID of the message to attach the notification to")
+	notifyCmd.Flags().Bool("escape", false, "Escape the message so it is shown as is in an HTML message")
+	notifyCmd.Flags().Bool("sanitize", false, "Remove unsupported or unsafe HTML from the message")
+	notifyCmd.Flags().String("template", "", "Template to render the notification from")
+	notifyCmd.Flags().Var(&varFlag{}, "var", "Variable of the template as name=value, can be repeated")
+	addCardFlags(notifyCmd)
+	notifyCmd.Flags().AddFlag(userFlag)
+	notifyCmd.Flags().AddFlag(spoolFlag)
+	notifyCmd.Flags().AddFlag(viaDaemonFlag)
+	notifyCmd.Flags().AddFlag(parallelFlag)
 }
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// fail is the path of a request that fails with 404
+		fail string
+		code int
+		// want are the messages each room received
+		want map[string][]string
+	}{
+		{
+			name: "room",
+			args: []string{"room", "notify", "--room", "ops", "--message", "deployed"},
+			want: map[string][]string{"ops": {"deployed"}, "sre": nil},
+		},
+		{
+			name: "rooms",
+			args: []string{"room", "notify", "--room", "ops,sre", "--message", "deployed"},
+			want: map[string][]string{"ops": {"deployed"}, "sre": {"deployed"}},
+		},
+		{
+			name: "repeated room flag",
+			args: []string{"room", "notify", "--room", "ops", "--room", "sre", "--message", "deployed"},
+			want: map[string][]string{"ops": {"deployed"}, "sre": {"deployed"}},
+		},
+		{
+			name: "escaped",
+			args: []string{"room", "notify", "--room", "ops", "--message", "a < b", "--escape"},
+			want: map[string][]string{"ops": {"a &lt; b"}, "sre": nil},
+		},
+		{
+			name: "long message",
+			args: []string{"room", "notify", "--room", "ops", "--format", "text", "--message", strings.Repeat("a", 9999) + "\nb"},
+			want: map[string][]string{"ops": {strings.Repeat("a", 9999), "b"}, "sre": nil},
+		},
+		{
+			name: "long message with accents",
+			args: []string{"room", "notify", "--room", "ops", "--format", "text", "--message", strings.Repeat("é", 10001)},
+			want: map[string][]string{"ops": {strings.Repeat("é", 10000), "é"}, "sre": nil},
+		},
+		{
+			name: "partial failure",
+			args: []string{"room", "notify", "--room", "ops,sre", "--message", "deployed"},
+			fail: "room/sre/notification",
+			code: internal.ExitPartial,
+			want: map[string][]string{"ops": {"deployed"}, "sre": nil},
+		},
+		{
+			name: "room not found",
+			args: []string{"room", "notify", "--room", "dev", "--message", "deployed"},
+			code: internal.ExitNotFound,
+			want: map[string][]string{"ops": nil, "sre": nil},
+		},
+		{
+			name: "no rooms found",
+			args: []string{"room", "notify", "--room", "dev,qa", "--message", "deployed"},
+			code: internal.ExitNotFound,
+			want: map[string][]string{"ops": nil, "sre": nil},
+		},
+		{
+			name: "no message",
+			args: []string{"room", "notify", "--room", "ops"},
+			code: internal.ExitUsage,
+			want: map[string][]string{"ops": nil, "sre": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, stop := startFake("ops", "sre")
+			defer stop()
+			if tt.fail != "" {
+				fake.Fail(tt.fail, http.StatusNotFound, 1)
+			}
+
+			_, err := run(t, tt.args...)
+			checkExitCode(t, err, tt.code)
+			for room, want := range tt.want {
+				var got []string
+				for _, m := range fake.Messages(room) {
+					got = append(got, m.Message)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("room %v received %q, want %q", room, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRefusedTokenIsForgotten(t *testing.T) {
+	fake, stop := startFake("ops")
+	defer stop()
+	notify := []string{"room", "notify", "--room", "ops", "--message", "deployed"}
+	if err := internal.WriteTokenCache(map[string]internal.CachedToken{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := run(t, notify...); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := internal.ReadTokenCache(); len(tokens) != 1 {
+		t.Fatalf("the token cache holds %d tokens, want 1", len(tokens))
+	}
+
+	fake.RevokeTokens()
+	_, err := run(t, notify...)
+	checkExitCode(t, err, internal.ExitAuth)
+	if tokens, _ := internal.ReadTokenCache(); len(tokens) != 0 {
+		t.Fatalf("the token cache holds %d tokens after the token was refused, want 0", len(tokens))
+	}
+
+	if _, err := run(t, notify...); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fake.Messages("ops")); got != 2 {
+		t.Errorf("room ops received %d messages, want 2", got)
+	}
+}
+
+func TestNotifyCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		// token is the personal token configured, with scopes
+		token  string
+		scopes []string
+		// granted are the scopes the add-on can be granted, if set
+		granted []string
+		// oauthID is the only add-on the server accepts, if set
+		oauthID string
+		code    int
+	}{
+		{name: "access token", code: internal.ExitOK},
+		{name: "unknown add-on", oauthID: "other", code: internal.ExitAuth},
+		{name: "scope not granted", granted: []string{hipchat.ScopeViewRoom}, code: internal.ExitPermission},
+		{name: "personal token", token: "personal", scopes: []string{hipchat.ScopeSendNotification}, code: internal.ExitOK},
+		{name: "personal token without scope", token: "personal", scopes: []string{hipchat.ScopeViewRoom}, code: internal.ExitPermission},
+		{name: "unknown personal token", token: "unknown", code: internal.ExitAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, stop := startFake("ops")
+			defer stop()
+			fake.Scopes = tt.granted
+			fake.OAuthID = tt.oauthID
+			if tt.scopes != nil {
+				fake.AddToken(tt.token, tt.scopes...)
+			}
+			if tt.token != "" {
+				os.Setenv("HIPCHAT_TOKEN", tt.token)
+				defer os.Unsetenv("HIPCHAT_TOKEN")
+			}
+
+			_, err := run(t, "room", "notify", "--room", "ops", "--message", "deployed")
+			checkExitCode(t, err, tt.code)
+		})
+	}
+}
+
+func TestNotifyUsers(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		token bool
+		code  int
+		// want are the messages each room and user received
+		want map[string][]string
+	}{
+		{
+			name:  "rooms and users",
+			args:  []string{"--room", "ops", "--user", "@alice", "--user", "bob@example.com"},
+			token: true,
+			want:  map[string][]string{"ops": {"deployed"}, "@alice": {"deployed"}, "@bob": {"deployed"}},
+		},
+		{
+			name:  "only users",
+			args:  []string{"--user", "@alice,@bob,@alice"},
+			token: true,
+			want:  map[string][]string{"ops": nil, "@alice": {"deployed"}, "@bob": {"deployed"}},
+		},
+		{
+			name:  "user not found",
+			args:  []string{"--room", "ops", "--user", "@carol"},
+			token: true,
+			code:  internal.ExitPartial,
+			want:  map[string][]string{"ops": {"deployed"}, "@alice": nil},
+		},
+		{
+			name: "add-on",
+			args: []string{"--room", "ops", "--user", "@alice"},
+			code: internal.ExitAuth,
+			want: map[string][]string{"ops": nil, "@alice": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, stop := startFake("ops")
+			defer stop()
+			fake.AddUser("Alice", "alice", "alice@example.com")
+			fake.AddUser("Bob", "bob", "bob@example.com")
+			if tt.token {
+				fake.AddToken("personal", hipchat.ScopeSendNotification, hipchat.ScopeSendMessage)
+				os.Setenv("HIPCHAT_TOKEN", "personal")
+				defer os.Unsetenv("HIPCHAT_TOKEN")
+			}
+
+			_, err := run(t, append([]string{"room", "notify", "--message", "deployed"}, tt.args...)...)
+			checkExitCode(t, err, tt.code)
+			for target, want := range tt.want {
+				messages := fake.Messages(target)
+				if strings.HasPrefix(target, "@") {
+					messages = fake.PrivateMessages(target)
+				}
+				var got []string
+				for _, m := range messages {
+					got = append(got, m.Message)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%v received %q, want %q", target, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyAttachTo(t *testing.T) {
+	fake, stop := startFake("ops")
+	defer stop()
+
+	if _, err := run(t, "room", "notify", "--room", "ops", "--message", "fixed", "--attach-to", "42"); err != nil {
+		t.Fatal(err)
+	}
+	var body string
+	for _, r := range fake.Requests() {
+		if strings.HasSuffix(r.Path, "/notification") {
+			body = string(r.Body)
+		}
+	}
+	if !strings.Contains(body, `"attach_to":"42"`) {
+		t.Errorf("request body = %v, want attach_to", body)
+	}
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+)
+
+// outboxCmd represents the outbox command
+var outboxCmd = &cobra.Command{
+	Use:   "outbox",
+	Short: "Manage notifications that could not be sent",
+	Long: `With --spool (or spool: true in the config file) notifications that could not be
+sent because HipChat was unreachable are kept in ~/.hipchat-cli/outbox.
+
+list:  show the notifications in the outbox
+flush: send the notifications in the outbox
+purge: remove notifications from the outbox
+
+Notifications older than spool_max_age (default 1h) or that failed spool_max_attempts
+times (default 10) are expired: flush drops them instead of sending them late.
+Run flush periodically, for example from cron:
+*/5 * * * * hipchat-cli outbox flush --quiet
+`,
+}
+
+// outboxListCmd represents the outbox list command
+var outboxListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the notifications in the outbox",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := internal.ReadOutbox()
+		if err != nil {
+			return err
+		}
+
+		result := internal.Result{
+			Value:  entries,
+			Header: []string{"ID", "PROFILE", "ROOM", "CREATED", "ATTEMPTS", "STATUS", "LAST ERROR"},
+		}
+		if entries == nil {
+			result.Value = []*internal.OutboxEntry{}
+		}
+		for _, e := range entries {
+			status := "pending"
+			if e.Expired() {
+				status = "expired"
+			}
+			result.Rows = append(result.Rows, []string{e.ID[:8], e.Profile, e.Room, e.CreatedAt.Format(time.RFC3339),
+				strconv.Itoa(e.Attempts), status, e.LastError})
+		}
+		if len(entries) == 0 {
+			result.Text = func(w io.Writer) error {
+				_, err := fmt.Fprintln(w, "The outbox is empty")
+				return err
+			}
+		}
+		return internal.PrintResult(result)
+	},
+}
+
+// outboxFlushCmd represents the outbox flush command
+var outboxFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Send the notifications in the outbox",
+	Long: `Sends the notifications in the outbox of the active profile, oldest first.
+Expired notifications are dropped. Flushing stops when HipChat cannot be reached.
+With --dry-run the notifications are printed and kept in the outbox.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		unlock, err := internal.LockOutbox()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		entries, err := internal.ReadOutbox()
+		if err != nil {
+			return err
+		}
+
+		sent, failed := 0, 0
+		for _, e := range entries {
+			if e.Profile != internal.ActiveProfile() {
+				internal.Debugf("Skipping %v of profile %v", e.ID, e.Profile)
+				continue
+			}
+			if e.Expired() && internal.DryRun() {
+				internal.Infof("Would drop expired notification %v to %v", e.ID, e.Room)
+				continue
+			}
+			if e.Expired() {
+				internal.Warnf("Dropping expired notification %v to %v from %v after %d attempt(s): %v",
+					e.ID, e.Room, e.CreatedAt.Format(time.RFC3339), e.Attempts, e.LastError)
+				if err := e.Remove(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := deliverNotification(e.Room, e.Notification)
+			if err == nil && internal.DryRun() {
+				continue
+			}
+			if err == nil {
+				internal.Infof("Sent notification %v to %v", e.ID, e.Room)
+				sent++
+				if err := e.Remove(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			failed++
+			e.Attempts++
+			e.LastAttempt = time.Now()
+			e.LastError = err.Error()
+			if internal.DryRun() {
+				// Keep the outbox as it is
+			} else if serr := e.Save(); serr != nil {
+				return serr
+			}
+			if internal.IsTransient(err) {
+				return fmt.Errorf("Could not send notification %v, %d sent: %w", e.ID, sent, err)
+			}
+			internal.Warnf("Could not send notification %v to %v: %v", e.ID, e.Room, err)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d notification(s) could not be sent, %d sent", failed, sent)
+		}
+		internal.Infof("Sent %d notification(s)", sent)
+		return nil
+	},
+}
+
+// outboxPurgeCmd represents the outbox purge command
+var outboxPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Remove notifications from the outbox",
+	Long: `Removes all notifications from the outbox, or only the expired ones with --expired.
+With --dry-run the notifications that would be removed are only shown.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		expiredOnly, _ := cmd.Flags().GetBool("expired")
+
+		unlock, err := internal.LockOutbox()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		entries, err := internal.ReadOutbox()
+		if err != nil {
+			return err
+		}
+
+		removed := 0
+		for _, e := range entries {
+			if expiredOnly && !e.Expired() {
+				continue
+			}
+			removed++
+			if internal.DryRun() {
+				internal.Infof("Would remove notification %v to %v", e.ID, e.Room)
+				continue
+			}
+			if err := e.Remove(); err != nil {
+				return err
+			}
+		}
+		if internal.DryRun() {
+			internal.Infof("Would remove %d notification(s) from the outbox", removed)
+			return nil
+		}
+		internal.Infof("Removed %d notification(s) from the outbox", removed)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(outboxCmd)
+	outboxCmd.AddCommand(outboxListCmd)
+	outboxCmd.AddCommand(outboxFlushCmd)
+	outboxCmd.AddCommand(outboxPurgeCmd)
+
+	requiresScopes(outboxFlushCmd, notifyScopes...)
+
+	outboxPurgeCmd.Flags().Bool("expired", false, "Only remove expired notifications")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+)
+
+func TestOutbox(t *testing.T) {
+	fake, stop := startFake("ops")
+	defer stop()
+	endpoint := os.Getenv("HIPCHAT_ENDPOINT")
+
+	// Spool a notification while HipChat cannot be reached
+	closed := httptest.NewServer(nil)
+	closed.Close()
+	os.Setenv("HIPCHAT_ENDPOINT", closed.URL+"/v2")
+	if _, err := run(t, "room", "notify", "--room", "ops", "--message", "deployed", "--spool"); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HIPCHAT_ENDPOINT", endpoint)
+
+	// Entries that were truncated or edited by hand are ignored
+	dir, err := internal.OutboxDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, entry := range map[string]string{
+		"no-id.json":     `{"room": "ops", "notification": {"message": "x"}}`,
+		"short-id.json":  `{"id": "1", "room": "ops", "notification": {"message": "x"}}`,
+		"truncated.json": `{"id": "6ba7b814-9dad-11d1-80b4-00c04fd430c8", "room": "ops"`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(entry), 0600); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(dir, name))
+	}
+
+	out, err := run(t, "outbox", "list", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []internal.OutboxEntry
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid output %q: %v", out, err)
+	}
+	if len(entries) != 1 || entries[0].Room != "ops" || entries[0].Notification.Message != "deployed" {
+		t.Fatalf("outbox list = %v, want the spooled notification", out)
+	}
+
+	if _, err := run(t, "outbox", "flush"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range fake.Messages("ops") {
+		got = append(got, m.Message)
+	}
+	if want := []string{"deployed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("room ops received %q, want %q", got, want)
+	}
+	if entries, _ := internal.ReadOutbox(); len(entries) != 0 {
+		t.Errorf("the outbox holds %d entries after a flush, want 0", len(entries))
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// profilesCmd represents the config profiles command
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "List the profiles defined in the config file",
+	Long: `Profiles allow a single config file to hold the settings of multiple
+HipChat servers or add-ons:
+
+default_profile: prod
+profiles:
+  prod:
+    oauthid: <secret>
+    oauthsecret: <secret>
+  onprem:
+    token: <secret>
+    endpoint: https://hipchat.example.com/v2/
+
+The active profile is marked with a *, secrets are masked.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names := internal.ProfileNames()
+		active := internal.ActiveProfile()
+		profiles := internal.Profiles()
+
+		list := []profile{}
+		result := internal.Result{Header: []string{"", "PROFILE", "ENDPOINT", "OAUTHID", "OAUTHSECRET", "TOKEN", "PROXY"}}
+		for _, name := range names {
+			p := profiles[name]
+			marker := ""
+			if name == active {
+				marker = "*"
+			}
+			list = append(list, profile{name, name == active, internal.MaskSecrets(p)})
+			result.Rows = append(result.Rows, []string{marker, name,
+				cast.ToString(p["endpoint"]),
+				cast.ToString(p["oauthid"]),
+				internal.MaskSecret(cast.ToString(p["oauthsecret"])),
+				internal.MaskSecret(cast.ToString(p["token"])),
+				cast.ToString(p["proxy"])})
+		}
+		result.Value = list
+
+		if len(names) == 0 {
+			result.Text = func(w io.Writer) error {
+				_, err := fmt.Fprintln(w, "No profiles defined in", viper.ConfigFileUsed())
+				return err
+			}
+		}
+		return internal.PrintResult(result)
+	},
+}
+
+// profile describes a profile in the output of config profiles.
+type profile struct {
+	Name     string      `json:"name"`
+	Active   bool        `json:"active"`
+	Settings interface{} `json:"settings"`
+}
+
+func init() {
+	configCmd.AddCommand(profilesCmd)
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"text/template"
 
+	"github.com/houtmanj/hipchat-cli/internal"
 	"github.com/spf13/cobra"
 )
 
@@ -85,7 +86,7 @@ func registerPlugin(cmd *cobra.Command) {
 	cmdOpen := exec.Command("open", url)
 	err = cmdOpen.Run()
 	if err != nil {
-		fmt.Println(err)
+		internal.Warnf("Could not open the browser: %v", err)
 	}
 
 }
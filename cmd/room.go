@@ -1,23 +1,112 @@
 package cmd
 
 import (
-	"fmt"
+	"strings"
 
+	"github.com/houtmanj/hipchat-cli/internal"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
+// roomFlag is the --room flag shared by all commands operating on a room.
+// It is bound to the room config key, so a default room can be configured.
+// Commands sending notifications accept multiple rooms and groups, see targets.
+var roomFlag = newRoomFlag()
+
+func newRoomFlag() *pflag.Flag {
+	flags := pflag.NewFlagSet("room", pflag.ContinueOnError)
+	flags.Var(&roomValue{}, "room", "Name of the room (default is the room config key)")
+	return flags.Lookup("room")
+}
+
+// userFlag is the --user flag of commands sending notifications, which sends
+// them to users as private messages.
+var userFlag = newUserFlag()
+
+func newUserFlag() *pflag.Flag {
+	flags := pflag.NewFlagSet("user", pflag.ContinueOnError)
+	flags.Var(&roomValue{}, "user", "ID, email or @mention name of a user to send a private message to, can be repeated")
+	return flags.Lookup("user")
+}
+
+// roomValue is the value of --room and --user. Repeating the flag adds
+// rooms or users, the value is a comma separated list.
+type roomValue []string
+
+func (r *roomValue) String() string { return strings.Join(*r, ",") }
+func (r *roomValue) Type() string   { return "string" }
+
+func (r *roomValue) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// singleRoom returns the room the command operates on, which may not be a
+// list of rooms.
+func singleRoom() (string, error) {
+	room := viper.GetString("room")
+	if strings.Contains(room, ",") {
+		return "", internal.UsageErrorf("this command operates on a single room, %v given", room)
+	}
+	return room, nil
+}
+
+// targets returns the rooms and users to send a notification to: the rooms
+// given by --room as a list of rooms and groups from the config file, and
+// the users given by --user.
+func targets(cmd *cobra.Command) (rooms, users []string, err error) {
+	rooms, err = internal.SplitRooms(viper.GetString("room"))
+	if err != nil {
+		return nil, nil, internal.WithExitCode(internal.ExitUsage, err)
+	}
+	if f := cmd.Flags().Lookup("user"); f != nil {
+		seen := map[string]bool{}
+		for _, value := range *f.Value.(*roomValue) {
+			for _, user := range strings.Split(value, ",") {
+				if user = strings.TrimSpace(user); user != "" && !seen[user] {
+					seen[user] = true
+					users = append(users, user)
+				}
+			}
+		}
+	}
+	if len(rooms) == 0 && len(users) == 0 {
+		return nil, nil, internal.UsageErrorf("Specification of a room is mandatory, use --room or --user")
+	}
+	return rooms, users, nil
+}
+
+// usersGiven reports if cmd was given users to send to with --user.
+func usersGiven(cmd *cobra.Command) bool {
+	f := cmd.Flags().Lookup("user")
+	return f != nil && f.Changed
+}
+
 // roomCmd represents the room command
 var roomCmd = &cobra.Command{
 	Use:   "room",
 	Short: "Perform actions on a hipchat room",
 	Long: `Allows you to perform common operations on a room. For example:
 
-notify: Send a message to a room
-topic:  get or set the topic
+notify:    Send a message to a room
+message:   post a message to a room as a user
+topic:     get or set the topic
+list:      list the rooms
+get:       show the details of a room
+create:    create a room
+update:    change the name, privacy, guest access or owner of a room
+archive:   archive a room, unarchive undoes it
+delete:    delete a room
+history:   show the messages sent to a room
+tail:      show the latest messages, and follow new ones
 `,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		if !cmd.Flag("room").Changed {
-			return fmt.Errorf("Specification of a room is mandatory, use --name")
+		if err := RootCmd.PersistentPreRunE(cmd, args); err != nil {
+			return err
+		}
+		if viper.GetString("room") == "" && !usersGiven(cmd) {
+			return internal.UsageErrorf("Specification of a room is mandatory, use --room")
 		}
 		return nil
 	},
@@ -26,5 +115,6 @@ topic:  get or set the topic
 func init() {
 	RootCmd.AddCommand(roomCmd)
 
-	roomCmd.PersistentFlags().String("room", "", "Name of the room")
+	roomCmd.PersistentFlags().AddFlag(roomFlag)
+	internal.BindFlag("room", roomFlag)
 }
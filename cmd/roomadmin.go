@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+var (
+	// manageRoomsScopes are the OAuth scopes needed to create and delete rooms.
+	manageRoomsScopes = []string{hipchat.ScopeManageRooms}
+	// updateRoomScopes are the OAuth scopes needed to change a room. Reading
+	// the room requires view_room, which admin_room does not include.
+	updateRoomScopes = []string{hipchat.ScopeViewRoom, hipchat.ScopeAdminRoom}
+)
+
+// roomCreateCmd represents the room create command
+var roomCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a room",
+	Long:  `Creates a room named --room, and shows its ID.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		room, err := singleRoom()
+		if err != nil {
+			return err
+		}
+		privacy := cmd.Flag("privacy").Value.String()
+		if privacy != privacyPublic && privacy != privacyPrivate {
+			return internal.UsageErrorf("invalid --privacy %v, use public or private", privacy)
+		}
+
+		c, err := internal.GetClient(manageRoomsScopes...)
+		if err != nil {
+			return err
+		}
+
+		create := &hipchat.CreateRoomRequest{
+			Name:        room,
+			Topic:       cmd.Flag("topic").Value.String(),
+			Privacy:     privacy,
+			OwnerUserID: cmd.Flag("owner").Value.String(),
+		}
+		create.GuestAccess, _ = cmd.Flags().GetBool("guest-access")
+
+		internal.Infof("Creating room %v", room)
+		r, resp, err := c.Room.Create(create)
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return internal.CheckResponse(resp, err)
+		}
+		if internal.DryRun() {
+			// Only the request is printed, the room was not created
+			return nil
+		}
+
+		return internal.PrintResult(internal.Result{
+			Value:  r,
+			Header: []string{"ID", "NAME"},
+			Rows:   [][]string{{strconv.Itoa(r.ID), room}},
+			Text: func(w io.Writer) error {
+				_, err := fmt.Fprintln(w, r.ID)
+				return err
+			},
+		})
+	},
+}
+
+// roomUpdateCmd represents the room update command
+var roomUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change the settings of a room",
+	Long: `Changes the name, privacy, guest access or owner of a room. Settings that are not
+given are left as they are.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flag("privacy").Changed {
+			privacy := cmd.Flag("privacy").Value.String()
+			if privacy != privacyPublic && privacy != privacyPrivate {
+				return internal.UsageErrorf("invalid --privacy %v, use public or private", privacy)
+			}
+		}
+		changed := false
+		for _, name := range []string{"name", "privacy", "guest-access", "owner"} {
+			changed = changed || cmd.Flag(name).Changed
+		}
+		if !changed {
+			return internal.UsageErrorf("nothing to change, use --name, --privacy, --guest-access or --owner")
+		}
+
+		return updateRoom(func(update *roomUpdate) {
+			if cmd.Flag("name").Changed {
+				update.Name = cmd.Flag("name").Value.String()
+			}
+			if cmd.Flag("privacy").Changed {
+				update.Privacy = cmd.Flag("privacy").Value.String()
+			}
+			if cmd.Flag("guest-access").Changed {
+				update.IsGuestAccess, _ = cmd.Flags().GetBool("guest-access")
+			}
+			if cmd.Flag("owner").Changed {
+				update.Owner = &hipchat.ID{ID: cmd.Flag("owner").Value.String()}
+			}
+		})
+	},
+}
+
+// roomArchiveCmd represents the room archive command
+var roomArchiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Archive a room",
+	Long:  `Archives a room, it can no longer be used until it is unarchived.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateRoom(func(update *roomUpdate) {
+			update.IsArchived = true
+		})
+	},
+}
+
+// roomUnarchiveCmd represents the room unarchive command
+var roomUnarchiveCmd = &cobra.Command{
+	Use:   "unarchive",
+	Short: "Unarchive a room",
+	Long:  `Unarchives a room, so it can be used again.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateRoom(func(update *roomUpdate) {
+			update.IsArchived = false
+		})
+	},
+}
+
+// roomUpdate is the body of PUT /room/{room}. Unlike hipchat.UpdateRoomRequest
+// it leaves out the owner of rooms without owner.
+type roomUpdate struct {
+	Name          string      `json:"name"`
+	Topic         string      `json:"topic"`
+	IsGuestAccess bool        `json:"is_guest_accessible"`
+	IsArchived    bool        `json:"is_archived"`
+	Privacy       string      `json:"privacy"`
+	Owner         *hipchat.ID `json:"owner,omitempty"`
+}
+
+// updateRoom changes the settings of the room given by --room. HipChat
+// replaces all settings, so the current settings are read and passed to
+// change first. As they are not read in a dry run, the request that would
+// be sent is not known and --dry-run is refused.
+func updateRoom(change func(*roomUpdate)) error {
+	room, err := singleRoom()
+	if err != nil {
+		return err
+	}
+	if internal.DryRun() {
+		return internal.UsageErrorf("--dry-run cannot be used to change a room, the request depends on the current settings of the room which are not read in a dry run")
+	}
+
+	c, err := internal.GetClient(updateRoomScopes...)
+	if err != nil {
+		return err
+	}
+	r, err := getRoom(c, room)
+	if err != nil {
+		return err
+	}
+
+	update := &roomUpdate{
+		Name:          r.Name,
+		Topic:         r.Topic,
+		Privacy:       r.Privacy,
+		IsArchived:    r.IsArchived,
+		IsGuestAccess: r.IsGuestAccessible,
+	}
+	if r.Owner.ID != 0 {
+		update.Owner = &hipchat.ID{ID: strconv.Itoa(r.Owner.ID)}
+	}
+	change(update)
+
+	internal.Infof("Updating room %v", room)
+	req, err := c.NewRequest("PUT", fmt.Sprintf("room/%s", room), nil, update)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req, nil)
+	if err != nil {
+		if resp != nil {
+			internal.DebugDump(httputil.DumpResponse(resp, true))
+		}
+		return internal.CheckResponse(resp, err)
+	}
+	return nil
+}
+
+// roomDeleteCmd represents the room delete command
+var roomDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a room",
+	Long: `Deletes a room and its history, which cannot be undone. Asks for confirmation
+unless --yes is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		room, err := singleRoom()
+		if err != nil {
+			return err
+		}
+
+		if yes, _ := cmd.Flags().GetBool("yes"); !yes && !internal.DryRun() {
+			confirmed, err := confirm(fmt.Sprintf("Delete room %v and its history?", room))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				return internal.UsageErrorf("room %v was not deleted", room)
+			}
+		}
+
+		c, err := internal.GetClient(manageRoomsScopes...)
+		if err != nil {
+			return err
+		}
+
+		internal.Infof("Deleting room %v", room)
+		resp, err := c.Room.Delete(room)
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return internal.CheckResponse(resp, err)
+		}
+		return nil
+	},
+}
+
+// confirm asks the user a yes or no question on the terminal.
+func confirm(question string) (bool, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, internal.UsageErrorf("confirmation needed but stdin is not a terminal, use --yes")
+	}
+
+	fmt.Fprintf(os.Stderr, "%v [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func init() {
+	roomCmd.AddCommand(roomCreateCmd)
+	roomCmd.AddCommand(roomUpdateCmd)
+	roomCmd.AddCommand(roomArchiveCmd)
+	roomCmd.AddCommand(roomUnarchiveCmd)
+	roomCmd.AddCommand(roomDeleteCmd)
+
+	requiresScopes(roomCreateCmd, manageRoomsScopes...)
+	requiresScopes(roomUpdateCmd, updateRoomScopes...)
+	requiresScopes(roomArchiveCmd, updateRoomScopes...)
+	requiresScopes(roomUnarchiveCmd, updateRoomScopes...)
+	requiresScopes(roomDeleteCmd, manageRoomsScopes...)
+
+	roomCreateCmd.Flags().String("topic", "", "Topic of the room")
+	roomCreateCmd.Flags().String("privacy", privacyPublic, "Privacy of the room: public or private")
+	roomCreateCmd.Flags().Bool("guest-access", false, "Allow guests to join the room")
+	roomCreateCmd.Flags().String("owner", "", "ID, email or @mention name of the owner (default the user of the token)")
+
+	roomUpdateCmd.Flags().String("name", "", "New name of the room")
+	roomUpdateCmd.Flags().String("privacy", "", "Privacy of the room: public or private")
+	roomUpdateCmd.Flags().Bool("guest-access", false, "Allow guests to join the room")
+	roomUpdateCmd.Flags().String("owner", "", "ID, email or @mention name of the new owner")
+
+	roomDeleteCmd.Flags().Bool("yes", false, "Delete without asking for confirmation")
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+)
+
+func TestRoomUpdate(t *testing.T) {
+	fake, stop := startFake()
+	defer stop()
+	fake.AddRoom("ops", "all quiet")
+
+	if _, err := run(t, "room", "update", "--room", "ops", "--privacy", "private"); err != nil {
+		t.Fatal(err)
+	}
+	var body string
+	for _, r := range fake.Requests() {
+		if r.Method == http.MethodPut {
+			body = string(r.Body)
+		}
+	}
+	if !strings.Contains(body, `"topic":"all quiet"`) || !strings.Contains(body, `"privacy":"private"`) {
+		t.Errorf("request body = %v, want the current topic and the new privacy", body)
+	}
+	if strings.Contains(body, "owner") {
+		t.Errorf("request body = %v, want no owner for a room without owner", body)
+	}
+
+	_, err := run(t, "room", "archive", "--room", "ops", "--dry-run")
+	checkExitCode(t, err, internal.ExitUsage)
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// viewRoomScopes are the OAuth scopes needed to list and view rooms.
+var viewRoomScopes = []string{hipchat.ScopeViewRoom}
+
+// roomPageSize is the number of rooms requested at once, the maximum allowed by HipChat.
+const roomPageSize = 1000
+
+// Privacy settings of a room.
+const (
+	privacyPublic  = "public"
+	privacyPrivate = "private"
+)
+
+// roomListOptions are the parameters of GET /room, which RoomService.List
+// does not support.
+type roomListOptions struct {
+	hipchat.ListOptions
+	IncludePrivate  bool `url:"include-private"`
+	IncludeArchived bool `url:"include-archived"`
+}
+
+// roomDetails is a room as returned by HipChat. hipchat.Room misspells the
+// key of the guest access setting.
+type roomDetails struct {
+	hipchat.Room
+	IsGuestAccessible bool `json:"is_guest_accessible"`
+}
+
+// getRoom returns the details of room.
+func getRoom(c *hipchat.Client, room string) (*roomDetails, error) {
+	req, err := c.NewRequest("GET", fmt.Sprintf("room/%s", room), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &roomDetails{}
+	resp, err := c.Do(req, r)
+	if err != nil {
+		if resp != nil {
+			internal.DebugDump(httputil.DumpResponse(resp, true))
+		}
+		return nil, internal.CheckResponse(resp, err)
+	}
+	return r, nil
+}
+
+// listRooms returns up to max rooms, or all rooms if max is 0. Only rooms
+// with the privacy are returned, unless it is empty.
+func listRooms(c *hipchat.Client, privacy string, includeArchived bool, max int) ([]hipchat.Room, error) {
+	opt := &roomListOptions{ListOptions: hipchat.ListOptions{MaxResults: roomPageSize}}
+	opt.IncludePrivate = privacy != privacyPublic
+	opt.IncludeArchived = includeArchived
+
+	rooms := []hipchat.Room{}
+	for max <= 0 || len(rooms) < max {
+		req, err := c.NewRequest("GET", "room", opt, nil)
+		if err != nil {
+			return nil, err
+		}
+		page := &hipchat.Rooms{}
+		resp, err := c.Do(req, page)
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return nil, internal.CheckResponse(resp, err)
+		}
+		for _, r := range page.Items {
+			if privacy == "" || r.Privacy == privacy {
+				rooms = append(rooms, r)
+			}
+		}
+		if len(page.Items) < opt.MaxResults || page.Links.Next == "" {
+			break
+		}
+		opt.StartIndex += len(page.Items)
+	}
+	if max > 0 && len(rooms) > max {
+		rooms = rooms[:max]
+	}
+	return rooms, nil
+}
+
+// roomListCmd represents the room list command
+var roomListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the rooms",
+	Long:  `Lists the rooms of the group, without archived rooms unless --include-archived is given.`,
+	// No --room is needed, unlike the other room commands
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return RootCmd.PersistentPreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privacy := cmd.Flag("privacy").Value.String()
+		if privacy != "" && privacy != privacyPublic && privacy != privacyPrivate {
+			return internal.UsageErrorf("invalid --privacy %v, use public or private", privacy)
+		}
+		max, _ := cmd.Flags().GetInt("max")
+
+		c, err := internal.GetClient(viewRoomScopes...)
+		if err != nil {
+			return err
+		}
+
+		includeArchived, _ := cmd.Flags().GetBool("include-archived")
+		rooms, err := listRooms(c, privacy, includeArchived, max)
+		if err != nil {
+			return err
+		}
+
+		result := internal.Result{Value: rooms, Header: []string{"ID", "NAME", "PRIVACY", "ARCHIVED", "TOPIC"}}
+		for _, r := range rooms {
+			result.Rows = append(result.Rows, []string{strconv.Itoa(r.ID), r.Name, r.Privacy, strconv.FormatBool(r.IsArchived), r.Topic})
+		}
+		return internal.PrintResult(result)
+	},
+}
+
+// roomGetCmd represents the room get command
+var roomGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Show the details of a room",
+	Long:  `Shows the details of a room, including its owner, participants and statistics.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		room, err := singleRoom()
+		if err != nil {
+			return err
+		}
+
+		c, err := internal.GetClient(viewRoomScopes...)
+		if err != nil {
+			return err
+		}
+
+		r, err := getRoom(c, room)
+		if err != nil {
+			return err
+		}
+		stats, resp, err := c.Room.GetStatistics(room)
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return internal.CheckResponse(resp, err)
+		}
+		r.Statistics = *stats
+
+		participants := make([]string, len(r.Participants))
+		for i, p := range r.Participants {
+			participants[i] = "@" + p.MentionName
+		}
+		owner := ""
+		if r.Owner.ID != 0 {
+			owner = fmt.Sprintf("%v (@%v)", r.Owner.Name, r.Owner.MentionName)
+		}
+
+		return internal.PrintResult(internal.Result{
+			Value:  r,
+			Header: []string{"ID", "NAME", "PRIVACY", "ARCHIVED", "OWNER", "PARTICIPANTS", "MESSAGES", "LAST ACTIVE"},
+			Rows: [][]string{{strconv.Itoa(r.ID), r.Name, r.Privacy, strconv.FormatBool(r.IsArchived), owner,
+				strconv.Itoa(len(r.Participants)), strconv.Itoa(r.Statistics.MessagesSent), r.Statistics.LastActive}},
+			Text: func(w io.Writer) error {
+				tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+				fmt.Fprintf(tw, "ID:\t%v\n", r.ID)
+				fmt.Fprintf(tw, "Name:\t%v\n", r.Name)
+				fmt.Fprintf(tw, "Topic:\t%v\n", r.Topic)
+				fmt.Fprintf(tw, "Privacy:\t%v\n", r.Privacy)
+				fmt.Fprintf(tw, "Archived:\t%v\n", r.IsArchived)
+				fmt.Fprintf(tw, "Guest access:\t%v\n", r.IsGuestAccessible)
+				if r.GuestAccessURL != "" {
+					fmt.Fprintf(tw, "Guest access URL:\t%v\n", r.GuestAccessURL)
+				}
+				fmt.Fprintf(tw, "Owner:\t%v\n", owner)
+				fmt.Fprintf(tw, "Participants:\t%v\n", participantList(participants))
+				fmt.Fprintf(tw, "Messages sent:\t%v\n", r.Statistics.MessagesSent)
+				fmt.Fprintf(tw, "Last active:\t%v\n", r.Statistics.LastActive)
+				fmt.Fprintf(tw, "Created:\t%v\n", r.Created)
+				fmt.Fprintf(tw, "XMPP JID:\t%v\n", r.XmppJid)
+				return tw.Flush()
+			},
+		})
+	},
+}
+
+// participantList formats the mention names of the participants of a room.
+func participantList(mentions []string) string {
+	if len(mentions) == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d: %v", len(mentions), mentions)
+}
+
+func init() {
+	roomCmd.AddCommand(roomListCmd)
+	roomCmd.AddCommand(roomGetCmd)
+
+	requiresScopes(roomListCmd, viewRoomScopes...)
+	requiresScopes(roomGetCmd, viewRoomScopes...)
+
+	roomListCmd.Flags().String("privacy", "", "Only list public or private rooms")
+	roomListCmd.Flags().Bool("include-archived", false, "Include archived rooms")
+	roomListCmd.Flags().Int("max", 0, "Maximum number of rooms to list (default all)")
+}
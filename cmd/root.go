@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/houtmanj/hipchat-cli/internal"
 	"github.com/spf13/cobra"
@@ -30,41 +31,136 @@ hipchat-cli room notify --room <room> --message <msg>
 hipchat-cli nagios --room production  --type service --status critical --service "Apache process" --output "ok - pid found" \
   --host main-web-100 --monitorurl https://nagios.com/dashboard/ \
   --actions "CreateTicket:http://jira.com"  --actions "Ack:http://nagios.com?a=ack&alert=x"
+
+Exit codes:
+0 success                     5 room, user or other resource not found
+1 other error                 6 rate limited by HipChat
+2 invalid flags or settings   7 HipChat server error
+3 invalid credentials         8 HipChat could not be reached
+4 missing scope or permission 9 sending to some of the rooms failed
 `,
-	SilenceUsage: true,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	// Commands defining their own PersistentPreRunE must call this one first.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		commandStarted = true
+		if _, _, err := internal.ParseOutputFormat(viper.GetString("output")); err != nil {
+			return internal.WithExitCode(internal.ExitUsage, err)
+		}
+		return nil
+	},
 }
 
+// commandStarted is set once the flags are parsed and a command is run.
+var commandStarted bool
+
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The exit code depends on the error, see internal.ExitCode.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+	if err := execute(); err != nil {
+		internal.LogError(err)
+		os.Exit(internal.ExitCode(err))
+	}
+}
+
+// execute runs the command given by the arguments and returns its error,
+// with the exit code to use.
+func execute() error {
+	commandStarted = false
+	err := RootCmd.Execute()
+	if err == nil {
+		return nil
+	}
+	if !commandStarted {
+		// Unknown commands and flags are reported by cobra before any command runs
+		err = internal.WithExitCode(internal.ExitUsage, fmt.Errorf("%v\nRun 'hipchat-cli --help' for usage.", err))
 	}
+	if internal.ExitCode(err) == internal.ExitAuth {
+		// A cached access token that was refused would be used again by the next command
+		internal.ForgetToken()
+	}
+	return err
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.hipchat-cli.yaml)")
-	RootCmd.PersistentFlags().BoolVar(&internal.DebugLogging, "debug", false, "Enable debugging")
+	RootCmd.PersistentFlags().Bool("debug", false, "Enable debugging")
+	RootCmd.PersistentFlags().BoolP("verbose", "v", false, "Show the progress of commands")
+	RootCmd.PersistentFlags().BoolP("quiet", "q", false, "Only show errors")
+	RootCmd.PersistentFlags().String("log-format", "text", "Format of the messages written to stderr: text or json")
+	RootCmd.PersistentFlags().StringP("output", "o", internal.OutputText, "Output format: text, json, yaml, table or template=<go template>")
+	RootCmd.PersistentFlags().String("profile", "", "Profile from the config file to use (default is default_profile from the config file)")
+	RootCmd.PersistentFlags().Duration("connect-timeout", 0, "Timeout for connecting to HipChat (default 10s)")
+	RootCmd.PersistentFlags().Duration("timeout", 0, "Timeout of a single request to HipChat (default 30s)")
+	RootCmd.PersistentFlags().String("ca-file", "", "PEM file with additional CA certificates to trust")
+	RootCmd.PersistentFlags().String("client-cert", "", "PEM file with the client certificate for mutual TLS")
+	RootCmd.PersistentFlags().String("client-key", "", "PEM file with the key of the client certificate")
+	RootCmd.PersistentFlags().Bool("insecure-skip-verify", false, "Do not verify the certificate of the HipChat server (insecure)")
+	RootCmd.PersistentFlags().String("tls-min-version", "", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.2)")
+	RootCmd.PersistentFlags().Bool("dry-run", false, "Print the requests that would change something instead of sending them")
+	RootCmd.PersistentFlags().BoolVar(&internal.NoTokenCache, "no-token-cache", false, "Always request a new access token instead of using the token cache")
 
-	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	internal.BindFlag("debug", RootCmd.PersistentFlags().Lookup("debug"))
+	internal.BindFlag("verbose", RootCmd.PersistentFlags().Lookup("verbose"))
+	internal.BindFlag("quiet", RootCmd.PersistentFlags().Lookup("quiet"))
+	internal.BindFlag("log_format", RootCmd.PersistentFlags().Lookup("log-format"))
+	internal.BindFlag("output", RootCmd.PersistentFlags().Lookup("output"))
+	internal.BindFlag("profile", RootCmd.PersistentFlags().Lookup("profile"))
+	internal.BindFlag("dry_run", RootCmd.PersistentFlags().Lookup("dry-run"))
+	internal.BindFlag("no_token_cache", RootCmd.PersistentFlags().Lookup("no-token-cache"))
+	for _, key := range []string{"connect_timeout", "timeout", "ca_file", "client_cert", "client_key", "insecure_skip_verify", "tls_min_version"} {
+		internal.BindFlag(key, RootCmd.PersistentFlags().Lookup(strings.Replace(key, "_", "-", -1)))
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	viper.SetConfigName(".hipchat-cli") // name of config file (without extension)
+	viper.AddConfigPath("$HOME")        // adding home directory as first search path
+	internal.SetupEnv()                 // read in HIPCHAT_ environment variables that match
+
+	configureLogging()
 	if cfgFile != "" { // enable ability to specify config file via flag
-		fmt.Println("Using specific configfile: ", cfgFile)
+		internal.Debugf("Using specific configfile: %v", cfgFile)
 		viper.SetConfigFile(cfgFile)
 	}
 
-	viper.SetConfigName(".hipchat-cli") // name of config file (without extension)
-	viper.AddConfigPath("$HOME")        // adding home directory as first search path
-	viper.AutomaticEnv()                // read in environment variables that match
-
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		configureLogging()
+		internal.Infof("Using config file: %v", viper.ConfigFileUsed())
+	}
+
+	// Errors are reported by internal.GetClient, so commands that do not
+	// talk to HipChat keep working with a broken profile.
+	if err := internal.ApplyProfile(); err == nil && internal.ActiveProfile() != "" {
+		configureLogging()
+		internal.Debugf("Using profile: %v", internal.ActiveProfile())
+	}
+
+	internal.NoTokenCache = viper.GetBool("no_token_cache")
+	internal.LogConfigSources()
+}
+
+// configureLogging applies the logging settings, which can come from flags,
+// the environment and the config file.
+func configureLogging() {
+	err := internal.ConfigureLogging(viper.GetBool("quiet"), viper.GetBool("verbose"), viper.GetBool("debug"), viper.GetString("log_format"))
+	if err != nil {
+		internal.Warnf("%v", err)
+	}
+}
+
+// requiresScopes adds the OAuth scopes cmd needs to its help.
+func requiresScopes(cmd *cobra.Command, scopes ...string) {
+	list := scopes[len(scopes)-1]
+	if len(scopes) > 1 {
+		list = strings.Join(scopes[:len(scopes)-1], ", ") + " and " + list + " scopes"
+	} else {
+		list += " scope"
 	}
+	cmd.Long = strings.TrimRight(cmd.Long, "\n") + "\n\nRequires the " + list + "."
 }
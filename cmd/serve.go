@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/nu7hatch/gouuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// maxPayloadSize limits the size of the notifications accepted by the daemon.
+const maxPayloadSize = 1 << 20
+
+// shutdownTimeout is the time the daemon takes to send the queued notifications when stopped.
+const shutdownTimeout = 30 * time.Second
+
+// tokenRefreshMargin is the time before its expiry at which the daemon replaces its access token.
+const tokenRefreshMargin = 2 * time.Minute
+
+// maxDaemonRooms limits the number of rooms the daemon has queued notifications for.
+const maxDaemonRooms = 1000
+
+var (
+	errQueueFull      = errors.New("the queue of the room is full")
+	errTooManyRooms   = fmt.Errorf("notifications are queued for %d rooms, the maximum", maxDaemonRooms)
+	errDaemonStopping = errors.New("the daemon is stopping")
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a daemon that sends notifications received over a socket or HTTP",
+	Long: `Runs a daemon that keeps a single authenticated client and sends the notifications
+it receives, so notifications do not each start a new process and request a new access token.
+
+The daemon listens on a unix socket, ~/.hipchat-cli/daemon.sock by default, and optionally
+on HTTP with --http. Notifications are queued per room and sent in order, one at a time per
+room. Notifications are sent to up to --concurrency rooms at the same time.
+
+room notify and nagios send their notification through the daemon with --via-daemon
+(or via_daemon: true in the config file), and send it directly when no daemon is running.
+
+API:
+POST /v1/notify   {"room": "ops", "message": "deployed", "color": "green", "notify": true}
+                  accepts all fields of a HipChat notification, like card and attach_to
+POST /v1/nagios   {"room": "ops", "type": "service", "status": "critical", "service": "http",
+                   "host": "web1", "output": "timeout", "monitorurl": "...", "actions": ["Ack:http://..."]}
+GET  /v1/status   the length of the queues and the number of sent notifications
+
+A notification is accepted with status 202, or with ?wait=1 sent before responding
+with status 204. Errors are returned as {"error": "...", "exit_code": 5}.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		queueSize, concurrency := viper.GetInt("daemon_queue_size"), viper.GetInt("daemon_concurrency")
+		if queueSize < 1 || concurrency < 1 {
+			return internal.UsageErrorf("--queue-size and --concurrency should be at least 1")
+		}
+		d := &daemon{
+			queues:    map[string]chan *daemonJob{},
+			queueSize: queueSize,
+			slots:     make(chan struct{}, concurrency),
+			started:   time.Now(),
+		}
+
+		// Fail early when the credentials are wrong
+		if _, err := d.getClient(); err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v1/notify", d.handleNotify)
+		mux.HandleFunc("/v1/nagios", d.handleNagios)
+		mux.HandleFunc("/v1/status", d.handleStatus)
+		server := &http.Server{Handler: mux}
+
+		listeners, err := daemonListeners()
+		if err != nil {
+			return err
+		}
+		errc := make(chan error, len(listeners))
+		for _, l := range listeners {
+			internal.Infof("Listening on %v %v", l.Addr().Network(), l.Addr())
+			go func(l net.Listener) { errc <- server.Serve(l) }(l)
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case s := <-signals:
+			internal.Infof("Received %v, stopping", s)
+		case err = <-errc:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+		d.stop(ctx)
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	},
+}
+
+// daemonListeners opens the unix socket and the HTTP address to listen on.
+func daemonListeners() ([]net.Listener, error) {
+	socket, err := daemonSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %v", socket)
+		}
+		internal.Debugf("Removing stale socket %v", socket)
+		os.Remove(socket)
+	}
+
+	unix, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		unix.Close()
+		return nil, err
+	}
+	listeners := []net.Listener{unix}
+
+	if addr := viper.GetString("daemon_http"); addr != "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+				internal.Warnf("%v is not a loopback address, anyone who can reach it can send notifications", addr)
+			}
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			unix.Close()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// daemonJob is a notification queued by the daemon.
+type daemonJob struct {
+	id   string
+	room string
+	n    *internal.Notification
+	// done receives the result of sending the notification, if not nil.
+	done chan error
+}
+
+// daemon queues notifications per room and sends them with a shared client.
+// Every room with queued notifications has a worker, which stops when the
+// queue is empty.
+type daemon struct {
+	queueSize int
+	// slots limits the number of notifications sent at the same time.
+	slots   chan struct{}
+	started time.Time
+
+	mu       sync.Mutex
+	queues   map[string]chan *daemonJob
+	stopping bool
+	workers  sync.WaitGroup
+
+	// clientMu guards the client, as GetClient is not safe for concurrent use.
+	clientMu sync.Mutex
+	client   *hipchat.Client
+	expiry   time.Time
+
+	sent, failed, spooled int64
+}
+
+// getClient returns the shared client, replacing it when its token is about to expire.
+func (d *daemon) getClient() (*hipchat.Client, error) {
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	if d.client != nil && (d.expiry.IsZero() || time.Until(d.expiry) > tokenRefreshMargin) {
+		return d.client, nil
+	}
+	c, err := internal.GetClient(notifyScopes...)
+	if err != nil {
+		return nil, err
+	}
+	d.client, d.expiry = c, internal.TokenExpiry()
+	return c, nil
+}
+
+// resetClient makes getClient create a new client.
+func (d *daemon) resetClient() {
+	d.clientMu.Lock()
+	d.client = nil
+	d.clientMu.Unlock()
+}
+
+// enqueue adds j to the queue of its room, starting the worker of the room if needed.
+func (d *daemon) enqueue(j *daemonJob) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopping {
+		return errDaemonStopping
+	}
+	q, ok := d.queues[j.room]
+	if !ok {
+		if len(d.queues) >= maxDaemonRooms {
+			return errTooManyRooms
+		}
+		q = make(chan *daemonJob, d.queueSize)
+		d.queues[j.room] = q
+		d.workers.Add(1)
+		go d.work(j.room, q)
+	}
+
+	select {
+	case q <- j:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// work sends the notifications queued for room in order, until the queue is
+// empty or closed.
+func (d *daemon) work(room string, q chan *daemonJob) {
+	defer d.workers.Done()
+	for j := range q {
+		d.slots <- struct{}{}
+		err := d.deliver(j)
+		<-d.slots
+		if j.done != nil {
+			j.done <- err
+		}
+
+		// Notifications are only queued while holding d.mu, so none can be
+		// added to q once it is removed
+		d.mu.Lock()
+		idle := len(q) == 0 && !d.stopping
+		if idle {
+			delete(d.queues, room)
+		}
+		d.mu.Unlock()
+		if idle {
+			return
+		}
+	}
+}
+
+// deliver sends a queued notification, storing it in the outbox if that
+// fails and spooling is enabled.
+func (d *daemon) deliver(j *daemonJob) error {
+	c, err := d.getClient()
+	if err == nil {
+		err = deliverNotificationWith(c, j.room, j.n)
+		if internal.ExitCode(err) == internal.ExitAuth && internal.AuthMode() == internal.AuthModeOAuth {
+			// The access token may have been revoked. A personal token
+			// would be refused again.
+			internal.ForgetToken()
+			d.resetClient()
+			if c, err = d.getClient(); err == nil {
+				err = deliverNotificationWith(c, j.room, j.n)
+			}
+		}
+	}
+
+	if err == nil {
+		atomic.AddInt64(&d.sent, 1)
+		internal.Infof("Sent notification %v to %v", j.id, j.room)
+		return nil
+	}
+	if spoolFailed(j.room, j.n, err) == nil {
+		atomic.AddInt64(&d.spooled, 1)
+		return nil
+	}
+	atomic.AddInt64(&d.failed, 1)
+	internal.Warnf("Could not send notification %v to %v: %v", j.id, j.room, err)
+	return err
+}
+
+// stop sends the queued notifications, until ctx is done.
+func (d *daemon) stop(ctx context.Context) {
+	d.mu.Lock()
+	d.stopping = true
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		internal.Warnf("Stopped before all queued notifications were sent")
+	}
+}
+
+func (d *daemon) handleNotify(w http.ResponseWriter, r *http.Request) {
+	var payload daemonNotification
+	if !decodePayload(w, r, &payload) {
+		return
+	}
+	if payload.Room == "" || (payload.Message == "" && payload.Card == nil) {
+		writeDaemonError(w, internal.UsageErrorf("room and message are mandatory"))
+		return
+	}
+	d.accept(w, r, payload.Room, &payload.Notification)
+}
+
+func (d *daemon) handleNagios(w http.ResponseWriter, r *http.Request) {
+	var payload nagiosInput
+	if !decodePayload(w, r, &payload) {
+		return
+	}
+	if payload.Room == "" {
+		writeDaemonError(w, internal.UsageErrorf("room is mandatory"))
+		return
+	}
+	if payload.Type == "" {
+		payload.Type = typeService.str
+	}
+
+	notif, err := parseNagiosInput(payload)
+	if err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	n, err := getNotification(notif)
+	if err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	d.accept(w, r, payload.Room, &internal.Notification{NotificationRequest: *n})
+}
+
+// accept queues the notification and responds, after it is sent if requested.
+func (d *daemon) accept(w http.ResponseWriter, r *http.Request, room string, n *internal.Notification) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	j := &daemonJob{id: id.String(), room: room, n: n}
+	wait := r.URL.Query().Get("wait") != ""
+	if wait {
+		j.done = make(chan error, 1)
+	}
+
+	if err := d.enqueue(j); err != nil {
+		writeDaemonError(w, internal.WithExitCode(internal.ExitServer, err))
+		return
+	}
+	internal.Debugf("Queued notification %v to %v", j.id, room)
+
+	if !wait {
+		writeDaemonResponse(w, http.StatusAccepted, daemonResponse{ID: j.id})
+		return
+	}
+	select {
+	case err := <-j.done:
+		if err != nil {
+			writeDaemonError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeDaemonResponse(w, http.StatusMethodNotAllowed, daemonResponse{Error: "use GET", ExitCode: internal.ExitUsage})
+		return
+	}
+
+	d.mu.Lock()
+	queues := map[string]int{}
+	for room, q := range d.queues {
+		queues[room] = len(q)
+	}
+	d.mu.Unlock()
+
+	json.NewEncoder(w).Encode(struct {
+		Started time.Time      `json:"started"`
+		Queues  map[string]int `json:"queues"`
+		Sent    int64          `json:"sent"`
+		Failed  int64          `json:"failed"`
+		Spooled int64          `json:"spooled"`
+	}{d.started, queues, atomic.LoadInt64(&d.sent), atomic.LoadInt64(&d.failed), atomic.LoadInt64(&d.spooled)})
+}
+
+// decodePayload decodes the JSON body of a POST request into v.
+func decodePayload(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeDaemonResponse(w, http.StatusMethodNotAllowed, daemonResponse{Error: "use POST", ExitCode: internal.ExitUsage})
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPayloadSize)).Decode(v); err != nil {
+		writeDaemonError(w, internal.UsageErrorf("invalid payload: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeDaemonError responds with err and the exit code hipchat-cli would have returned.
+func writeDaemonError(w http.ResponseWriter, err error) {
+	code := internal.ExitCode(err)
+	status := http.StatusBadGateway
+	switch code {
+	case internal.ExitUsage:
+		status = http.StatusBadRequest
+	case internal.ExitServer:
+		status = http.StatusServiceUnavailable
+	}
+	writeDaemonResponse(w, status, daemonResponse{Error: err.Error(), ExitCode: code})
+}
+
+func writeDaemonResponse(w http.ResponseWriter, status int, r daemonResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	requiresScopes(serveCmd, notifyScopes...)
+
+	serveCmd.Flags().String("socket", "", "Unix socket to listen on (default is ~/.hipchat-cli/daemon.sock)")
+	serveCmd.Flags().String("http", "", "Address to listen on for HTTP, like 127.0.0.1:8787")
+	serveCmd.Flags().Int("queue-size", 100, "Maximum number of queued notifications per room")
+	serveCmd.Flags().Int("concurrency", 4, "Maximum number of rooms notifications are sent to at the same time, one at a time per room")
+
+	internal.BindFlag("daemon_socket", serveCmd.Flags().Lookup("socket"))
+	internal.BindFlag("daemon_http", serveCmd.Flags().Lookup("http"))
+	internal.BindFlag("daemon_queue_size", serveCmd.Flags().Lookup("queue-size"))
+	internal.BindFlag("daemon_concurrency", serveCmd.Flags().Lookup("concurrency"))
+	viper.SetDefault("daemon_queue_size", 100)
+	viper.SetDefault("daemon_concurrency", 4)
+}
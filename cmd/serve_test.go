@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+)
+
+func TestDaemon(t *testing.T) {
+	rooms := []string{"ops", "sre", "dev"}
+	fake, stop := startFake(rooms...)
+	defer stop()
+	// Read the config file, as serve would
+	initConfig()
+
+	d := &daemon{
+		queues:    map[string]chan *daemonJob{},
+		queueSize: 100,
+		slots:     make(chan struct{}, 2),
+		started:   time.Now(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(d.handleNotify))
+	defer server.Close()
+	notify := func(room, message, query string) int {
+		body := fmt.Sprintf(`{"room": %q, "message": %q}`, room, message)
+		resp, err := http.Post(server.URL+query, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The rooms are sent to at the same time, the messages of a room in order
+	const count = 20
+	for i := 0; i < count; i++ {
+		for _, room := range rooms {
+			if status := notify(room, strconv.Itoa(i), ""); status != http.StatusAccepted {
+				t.Fatalf("status = %d, want %d", status, http.StatusAccepted)
+			}
+		}
+	}
+	for _, room := range rooms {
+		if status := notify(room, strconv.Itoa(count), "?wait=1"); status != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", status, http.StatusNoContent)
+		}
+	}
+
+	var want []string
+	for i := 0; i <= count; i++ {
+		want = append(want, strconv.Itoa(i))
+	}
+	for _, room := range rooms {
+		var got []string
+		for _, m := range fake.Messages(room) {
+			got = append(got, m.Message)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("room %v received %q, want %q", room, got, want)
+		}
+	}
+
+	// The queues are removed once they are empty
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.Lock()
+		queues := len(d.queues)
+		d.mu.Unlock()
+		if queues == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queues are left", queues)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d.stop(ctx)
+	if status := notify("ops", "late", ""); status != http.StatusBadGateway && status != http.StatusServiceUnavailable {
+		t.Errorf("status after stop = %d, want an error", status)
+	}
+}
+
+func TestDaemonRefusedToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// token is the personal token configured, if any
+		token string
+		// attempts are the notification requests made
+		attempts int
+	}{
+		{name: "access token", attempts: 2},
+		{name: "personal token", token: "unknown", attempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, stop := startFake("ops")
+			defer stop()
+			fake.Fail("room/ops/notification", http.StatusUnauthorized, 2)
+			if tt.token != "" {
+				os.Setenv("HIPCHAT_TOKEN", tt.token)
+				defer os.Unsetenv("HIPCHAT_TOKEN")
+			}
+			initConfig()
+
+			d := &daemon{}
+			n := &internal.Notification{}
+			n.Message = "deployed"
+			err := d.deliver(&daemonJob{id: "1", room: "ops", n: n})
+			checkExitCode(t, err, internal.ExitAuth)
+			attempts := 0
+			for _, r := range fake.Requests() {
+				if strings.HasSuffix(r.Path, "/notification") {
+					attempts++
+				}
+			}
+			if attempts != tt.attempts {
+				t.Errorf("sent %d notification requests, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
@@ -1,44 +1,74 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"net/http/httputil"
 
 	"github.com/houtmanj/hipchat-cli/internal"
 	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
 )
 
 var topic string
 
+// The OAuth scopes needed to get and set the topic.
+var (
+	getTopicScopes = []string{hipchat.ScopeViewRoom}
+	setTopicScopes = []string{hipchat.ScopeAdminRoom}
+)
+
 // topicCmd represents the topic command
 var topicCmd = &cobra.Command{
 	Use:   "topic",
 	Short: "Set or Get the topic of a room",
-	Long:  ``,
+	Long: `Without --topic the current topic is shown.
+
+Getting the topic requires the view_room scope, setting it the admin_room scope.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		c, err := internal.GetClient()
+		room, err := singleRoom()
 		if err != nil {
 			return err
 		}
-		room := cmd.Flag("room").Value.String()
 
 		if !cmd.Flag("topic").Changed {
+			c, err := internal.GetClient(getTopicScopes...)
+			if err != nil {
+				return err
+			}
+
 			r, resp, err := c.Room.Get(room)
 
 			if err != nil {
-				internal.Debug(httputil.DumpResponse(resp, true))
-				return err
+				if resp != nil {
+					internal.DebugDump(httputil.DumpResponse(resp, true))
+				}
+				return internal.CheckResponse(resp, err)
 			}
 
-			cmd.Printf("topic: %v", r.Topic)
-			return nil
+			return internal.PrintResult(internal.Result{
+				Value:  r,
+				Header: []string{"ROOM", "TOPIC"},
+				Rows:   [][]string{{r.Name, r.Topic}},
+				Text: func(w io.Writer) error {
+					_, err := fmt.Fprintln(w, r.Topic)
+					return err
+				},
+			})
+		}
+		c, err := internal.GetClient(setTopicScopes...)
+		if err != nil {
+			return err
 		}
-		cmd.Printf("Setting topic for '%v' to '%v'\n", room, topic)
+
+		internal.Infof("Setting topic for '%v' to '%v'", room, topic)
 
 		resp, err := c.Room.SetTopic(room, topic)
 		if err != nil {
-			internal.Debug(httputil.DumpResponse(resp, true))
-			cmd.Printf("failed to set topic: %v", err)
-			return err
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return internal.CheckResponse(resp, err)
 		}
 
 		return nil
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+)
+
+func TestTopic(t *testing.T) {
+	fake, stop := startFake()
+	defer stop()
+	fake.AddRoom("ops", "all quiet")
+
+	// The steps run in order against the same server
+	steps := []struct {
+		name string
+		args []string
+		code int
+		want string
+	}{
+		{"get", []string{"room", "topic", "--room", "ops"}, internal.ExitOK, "all quiet\n"},
+		{"set", []string{"room", "topic", "--room", "ops", "--topic", "deploying"}, internal.ExitOK, ""},
+		{"get after set", []string{"room", "topic", "--room", "ops"}, internal.ExitOK, "deploying\n"},
+		{"get template", []string{"room", "topic", "--room", "ops", "--output", "template={{.Name}}: {{.Topic}}"}, internal.ExitOK, "ops: deploying\n"},
+		{"get room not found", []string{"room", "topic", "--room", "dev"}, internal.ExitNotFound, ""},
+		{"set room not found", []string{"room", "topic", "--room", "dev", "--topic", "x"}, internal.ExitNotFound, ""},
+		{"several rooms", []string{"room", "topic", "--room", "ops,dev"}, internal.ExitUsage, ""},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			out, err := run(t, step.args...)
+			checkExitCode(t, err, step.code)
+			if out != step.want {
+				t.Errorf("output = %q, want %q", out, step.want)
+			}
+		})
+	}
+
+	out, err := run(t, "room", "topic", "--room", "ops", "--topic", "ignored", "--dry-run")
+	checkExitCode(t, err, internal.ExitOK)
+	if !strings.Contains(out, "PUT ") || !strings.Contains(out, `"topic": "ignored"`) {
+		t.Errorf("dry run output = %q, want the request", out)
+	}
+	if out, _ := run(t, "room", "topic", "--room", "ops"); out != "deploying\n" {
+		t.Errorf("topic after a dry run = %q, want %q", out, "deploying\n")
+	}
+}
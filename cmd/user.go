@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"net/http/httputil"
+	"strconv"
+
+	"github.com/houtmanj/hipchat-cli/internal"
+	"github.com/spf13/cobra"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// userScopes are the OAuth scopes needed to view users.
+var userScopes = []string{hipchat.ScopeViewGroup}
+
+// userPageSize is the number of users requested at once, the maximum allowed by HipChat.
+const userPageSize = 1000
+
+// userCmd represents the user command
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Show the users of the group",
+	Long: `Allows you to inspect the users of the group. For example:
+
+list: list all users
+get:  show the details of a user`,
+}
+
+// userListCmd represents the user list command
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all users",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := internal.GetClient(userScopes...)
+		if err != nil {
+			return err
+		}
+
+		opt := &hipchat.UserListOptions{ListOptions: hipchat.ListOptions{MaxResults: userPageSize}}
+		opt.IncludeGuests, _ = cmd.Flags().GetBool("include-guests")
+		opt.IncludeDeleted, _ = cmd.Flags().GetBool("include-deleted")
+
+		users := []hipchat.User{}
+		for {
+			page, resp, err := c.User.List(opt)
+			if err != nil {
+				if resp != nil {
+					internal.DebugDump(httputil.DumpResponse(resp, true))
+				}
+				return internal.CheckResponse(resp, err)
+			}
+			users = append(users, page...)
+			if len(page) < opt.MaxResults {
+				break
+			}
+			opt.StartIndex += len(page)
+		}
+
+		result := internal.Result{Value: users, Header: []string{"ID", "NAME", "MENTION", "EMAIL", "TITLE"}}
+		for _, u := range users {
+			result.Rows = append(result.Rows, []string{strconv.Itoa(u.ID), u.Name, "@" + u.MentionName, u.Email, u.Title})
+		}
+		return internal.PrintResult(result)
+	},
+}
+
+// userGetCmd represents the user get command
+var userGetCmd = &cobra.Command{
+	Use:   "get <id|email|@mention>",
+	Short: "Show the details of a user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return internal.UsageErrorf("specify the id, email or @mention name of the user")
+		}
+
+		c, err := internal.GetClient(userScopes...)
+		if err != nil {
+			return err
+		}
+
+		u, resp, err := c.User.View(args[0])
+		if err != nil {
+			if resp != nil {
+				internal.DebugDump(httputil.DumpResponse(resp, true))
+			}
+			return internal.CheckResponse(resp, err)
+		}
+
+		return internal.PrintResult(internal.Result{
+			Value:  u,
+			Header: []string{"ID", "NAME", "MENTION", "EMAIL", "TITLE", "PRESENCE", "TIMEZONE"},
+			Rows: [][]string{{strconv.Itoa(u.ID), u.Name, "@" + u.MentionName, u.Email, u.Title,
+				u.Presence.Show, u.Timezone}},
+		})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userListCmd)
+	userCmd.AddCommand(userGetCmd)
+
+	requiresScopes(userCmd, userScopes...)
+
+	userListCmd.Flags().Bool("include-guests", false, "Include guest users")
+	userListCmd.Flags().Bool("include-deleted", false, "Include deleted users")
+}
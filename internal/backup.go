@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupManifestFile is the name of the manifest in a backup directory.
+	BackupManifestFile = "manifest.json"
+	// backupVersion is the version of the layout of backup directories.
+	backupVersion = 1
+	// backupDayLayout names the file with the messages of a day.
+	backupDayLayout = "2006-01-02"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Backup is a directory with the history of rooms. Every room has a
+// directory with a JSON file per day, holding the messages sent that day
+// in UTC, oldest first. The manifest lists the rooms and up to which
+// message they are backed up.
+type Backup struct {
+	Version int           `json:"version"`
+	Updated time.Time     `json:"updated"`
+	Rooms   []*BackupRoom `json:"rooms"`
+
+	dir string
+}
+
+// BackupRoom is the state of the backup of a room.
+type BackupRoom struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Privacy    string `json:"privacy,omitempty"`
+	IsArchived bool   `json:"is_archived"`
+	// Dir is the directory with the messages, relative to the backup.
+	Dir      string `json:"dir"`
+	Messages int    `json:"messages"`
+	Days     int    `json:"days"`
+	// The first and the last message backed up. The next backup starts at
+	// the last message.
+	FirstMessageDate string    `json:"first_message_date,omitempty"`
+	LastMessageID    string    `json:"last_message_id,omitempty"`
+	LastMessageDate  string    `json:"last_message_date,omitempty"`
+	Updated          time.Time `json:"updated"`
+}
+
+// OpenBackup returns the backup in dir, creating dir if needed.
+func OpenBackup(dir string) (*Backup, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Error while creating backup directory: %v", err)
+	}
+	b := &Backup{Version: backupVersion, Rooms: []*BackupRoom{}, dir: dir}
+	data, err := ioutil.ReadFile(filepath.Join(dir, BackupManifestFile))
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading backup manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("Error while reading backup manifest %v: %v", filepath.Join(dir, BackupManifestFile), err)
+	}
+	if b.Version != backupVersion {
+		return nil, fmt.Errorf("backup in %v has version %d, only version %d is supported", dir, b.Version, backupVersion)
+	}
+	return b, nil
+}
+
+// Room returns the state of the room with id, which is added if it was
+// not backed up before.
+func (b *Backup) Room(id int, name string) *BackupRoom {
+	for _, r := range b.Rooms {
+		if r.ID == id {
+			return r
+		}
+	}
+	r := &BackupRoom{ID: id, Name: name, Dir: fmt.Sprintf("%d-%v", id, strings.Trim(unsafeNameChars.ReplaceAllString(name, "_"), "_"))}
+	b.Rooms = append(b.Rooms, r)
+	sort.Slice(b.Rooms, func(i, j int) bool { return b.Rooms[i].ID < b.Rooms[j].ID })
+	return r
+}
+
+// Save writes the manifest.
+func (b *Backup) Save() error {
+	b.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(b.dir, BackupManifestFile), append(data, '\n'), 0600)
+}
+
+// AddMessages stores messages of room r in the files of their days. Messages
+// that are already stored are skipped. It returns the number of messages added.
+func (b *Backup) AddMessages(r *BackupRoom, messages []HistoryMessage) (int, error) {
+	dir := filepath.Join(b.dir, r.Dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, fmt.Errorf("Error while creating backup directory: %v", err)
+	}
+
+	days := map[string][]HistoryMessage{}
+	for _, m := range messages {
+		t, err := m.Time()
+		if err != nil {
+			return 0, fmt.Errorf("message %v has an invalid date: %v", m.ID, err)
+		}
+		day := t.UTC().Format(backupDayLayout)
+		days[day] = append(days[day], m)
+	}
+
+	added := 0
+	for day, dayMessages := range days {
+		file := filepath.Join(dir, day+".json")
+		stored, err := readBackupDay(file)
+		if err != nil {
+			return added, err
+		}
+		if len(stored) == 0 {
+			r.Days++
+		}
+
+		ids := map[string]bool{}
+		for _, m := range stored {
+			ids[m.ID] = true
+		}
+		for _, m := range dayMessages {
+			if !ids[m.ID] {
+				ids[m.ID] = true
+				stored = append(stored, m)
+				added++
+				r.Messages++
+				if r.FirstMessageDate == "" || m.Date < r.FirstMessageDate {
+					r.FirstMessageDate = m.Date
+				}
+			}
+		}
+
+		if err := writeBackupDay(file, stored); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+func readBackupDay(file string) ([]HistoryMessage, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading backup: %v", err)
+	}
+	var messages []HistoryMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("Error while reading backup %v: %v", file, err)
+	}
+	return messages, nil
+}
+
+// writeBackupDay writes the messages of a day as HipChat returned them, oldest first.
+func writeBackupDay(file string, messages []HistoryMessage) error {
+	sort.SliceStable(messages, func(i, j int) bool {
+		ti, _ := messages[i].Time()
+		tj, _ := messages[j].Time()
+		return ti.Before(tj)
+	})
+	raw := make([]json.RawMessage, len(messages))
+	for i, m := range messages {
+		raw[i] = m.Raw
+	}
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, append(data, '\n'), 0600)
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tbruyelle/hipchat-go/hipchat"
+	"gopkg.in/yaml.v2"
+)
+
+// Limits of the fields of a card, as documented by HipChat.
+const (
+	maxCardTitleLength       = 500
+	maxCardDescriptionLength = 1000
+	maxCardIDLength          = 255
+	maxCardAttributes        = 10
+	maxAttributeLabelLength  = 50
+	maxAttributeValueLength  = 1000
+)
+
+// CardStyles are the styles of a card.
+var CardStyles = []string{hipchat.CardStyleFile, hipchat.CardStyleImage, hipchat.CardStyleApplication, hipchat.CardStyleLink, hipchat.CardStyleMedia}
+
+// AttributeStyles are the styles of the value of a card attribute.
+var AttributeStyles = []string{"lozenge-success", "lozenge-error", "lozenge-current", "lozenge-complete", "lozenge-moved", "lozenge"}
+
+// ReadCardFile reads a card from a JSON or YAML file, or from stdin if file
+// is "-". Files ending in .yaml or .yml are read as YAML.
+func ReadCardFile(file string) (*hipchat.Card, error) {
+	data, err := ReadMessage(file)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := []byte(data)
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".yaml" || ext == ".yml" {
+		var generic interface{}
+		if err := yaml.Unmarshal(doc, &generic); err != nil {
+			return nil, fmt.Errorf("Error while parsing card %v: %v", file, err)
+		}
+		if doc, err = json.Marshal(stringMapValue(generic)); err != nil {
+			return nil, fmt.Errorf("Error while parsing card %v: %v", file, err)
+		}
+	}
+
+	card := &hipchat.Card{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(card); err != nil {
+		return nil, fmt.Errorf("Error while parsing card %v: %v", file, err)
+	}
+	return card, nil
+}
+
+// ValidateCard checks the card against the constraints of HipChat, so an
+// invalid card is reported before it is sent.
+func ValidateCard(c *hipchat.Card) error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !stringInSlice(c.Style, CardStyles) {
+		addf("style %q should be one of: %v", c.Style, strings.Join(CardStyles, ", "))
+	}
+	if c.Title == "" {
+		addf("title is required")
+	} else if n := utf8.RuneCountInString(c.Title); n > maxCardTitleLength {
+		addf("title is %d characters, the maximum is %d", n, maxCardTitleLength)
+	}
+	if c.ID == "" || len(c.ID) > maxCardIDLength {
+		addf("id should be 1 to %d characters", maxCardIDLength)
+	}
+	if c.Format != "" && c.Format != "compact" && c.Format != "medium" {
+		addf("format %q should be compact or medium", c.Format)
+	}
+
+	if c.Description.Format != "" && c.Description.Format != "html" && c.Description.Format != "text" {
+		addf("description format %q should be html or text", c.Description.Format)
+	}
+	if n := utf8.RuneCountInString(c.Description.Value); n > maxCardDescriptionLength {
+		addf("description is %d characters, the maximum is %d", n, maxCardDescriptionLength)
+	}
+
+	switch c.Style {
+	case hipchat.CardStyleLink, hipchat.CardStyleFile, hipchat.CardStyleImage, hipchat.CardStyleMedia:
+		if c.URL == "" {
+			addf("url is required for style %v", c.Style)
+		}
+	}
+	if c.Style == hipchat.CardStyleImage && c.Thumbnail == nil {
+		addf("thumbnail is required for style %v", c.Style)
+	}
+	if c.URL != "" && !validURL(c.URL) {
+		addf("url %q should be an http or https URL", c.URL)
+	}
+	if c.Icon != nil && !validURL(c.Icon.URL) {
+		addf("icon url %q should be an http or https URL", c.Icon.URL)
+	}
+	if c.Thumbnail != nil && !validURL(c.Thumbnail.URL) {
+		addf("thumbnail url %q should be an http or https URL", c.Thumbnail.URL)
+	}
+	if c.Activity != nil && c.Activity.HTML == "" {
+		addf("activity html is required when an activity is given")
+	}
+
+	if len(c.Attributes) > maxCardAttributes {
+		addf("%d attributes given, the maximum is %d", len(c.Attributes), maxCardAttributes)
+	}
+	for i, a := range c.Attributes {
+		if n := utf8.RuneCountInString(a.Label); n > maxAttributeLabelLength {
+			addf("label of attribute %d is %d characters, the maximum is %d", i+1, n, maxAttributeLabelLength)
+		}
+		if n := utf8.RuneCountInString(a.Value.Label); n == 0 || n > maxAttributeValueLength {
+			addf("value of attribute %d should be 1 to %d characters", i+1, maxAttributeValueLength)
+		}
+		if a.Value.Style != "" && !stringInSlice(a.Value.Style, AttributeStyles) {
+			addf("style %q of attribute %d should be one of: %v", a.Value.Style, i+1, strings.Join(AttributeStyles, ", "))
+		}
+		if a.Value.URL != "" && !validURL(a.Value.URL) {
+			addf("url %q of attribute %d should be an http or https URL", a.Value.URL, i+1)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid card: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validURL reports if u is an absolute http or https URL.
+func validURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// secretKeys are configuration keys holding credentials, their values are
+// masked whenever the configuration is shown.
+var secretKeys = []string{"oauthsecret", "token"}
+
+// authKeys are the configuration keys of the credentials of each
+// authentication mode.
+var authKeys = map[string][]string{
+	AuthModeToken: {"token", "token_file", "token_command"},
+	AuthModeOAuth: {"oauthid", "oauthsecret", "oauthsecret_file", "oauthsecret_command"},
+}
+
+// configErr is set when the configuration could not be applied,
+// it is returned by GetClient.
+var configErr error
+
+// ActiveProfile returns the name of the selected profile.
+// The profile key (set by --profile or HIPCHAT_PROFILE) takes precedence over default_profile.
+// An empty string means no profile is used.
+func ActiveProfile() string {
+	if p := viper.GetString("profile"); p != "" {
+		return p
+	}
+	return viper.GetString("default_profile")
+}
+
+// Profiles returns the profiles defined in the config file, indexed by name.
+func Profiles() map[string]map[string]interface{} {
+	profiles := map[string]map[string]interface{}{}
+	for name, p := range cast.ToStringMap(viper.Get("profiles")) {
+		profiles[name] = StringMap(p)
+	}
+	return profiles
+}
+
+// ProfileNames returns the sorted names of the profiles in the config file.
+func ProfileNames() []string {
+	var names []string
+	for name := range Profiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile merges the settings of the active profile over the top level
+// settings of the config file. Flags and environment variables still take
+// precedence over the settings in the profile. A profile with credentials
+// of one authentication mode clears the top level credentials of the other,
+// so it can switch the authentication mode.
+func ApplyProfile() error {
+	configErr = applyProfile()
+	return configErr
+}
+
+func applyProfile() error {
+	name := ActiveProfile()
+	if name == "" {
+		return nil
+	}
+
+	p, ok := Profiles()[name]
+	if !ok {
+		return fmt.Errorf("Profile %v is not defined in the config file", name)
+	}
+
+	for mode, keys := range authKeys {
+		if !hasAnyKey(p, keys) {
+			continue
+		}
+		for other, otherKeys := range authKeys {
+			if other == mode {
+				continue
+			}
+			for _, key := range otherKeys {
+				if _, ok := p[key]; !ok {
+					p[key] = ""
+				}
+			}
+		}
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Could not read profile %v: %v", name, err)
+	}
+	if err := viper.MergeConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("Could not apply profile %v: %v", name, err)
+	}
+	return nil
+}
+
+func hasAnyKey(m map[string]interface{}, keys []string) bool {
+	for _, key := range keys {
+		if _, ok := m[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSecretKey reports if the values of key should not be shown.
+func IsSecretKey(key string) bool {
+	for _, k := range secretKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskSecret hides all but the last characters of a secret.
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 12 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// MaskSecrets returns value with the values of all secret keys within
+// (nested) maps masked.
+func MaskSecrets(value interface{}) interface{} {
+	switch value.(type) {
+	case map[interface{}]interface{}, map[string]interface{}:
+		m := StringMap(value)
+		for k, v := range m {
+			if IsSecretKey(k) {
+				m[k] = MaskSecret(cast.ToString(v))
+			} else {
+				m[k] = MaskSecrets(v)
+			}
+		}
+		return m
+	default:
+		return value
+	}
+}
+
+// StringMap converts a (nested) map as returned by the yaml parser into a
+// map with string keys, so it can be encoded as JSON.
+func StringMap(in interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range cast.ToStringMap(in) {
+		out[k] = stringMapValue(v)
+	}
+	return out
+}
+
+func stringMapValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}, map[string]interface{}:
+		return StringMap(t)
+	case []interface{}:
+		values := make([]interface{}, len(t))
+		for i, e := range t {
+			values[i] = stringMapValue(e)
+		}
+		return values
+	default:
+		return v
+	}
+}
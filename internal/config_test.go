@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestApplyProfileAuthMode(t *testing.T) {
+	config := `
+token: toplevel
+oauthsecret_file: /secret
+profiles:
+  addon:
+    oauthid: addon
+    oauthsecret: secret
+  personal:
+    token_command: echo personal
+  room:
+    room: ops
+`
+	tests := []struct {
+		profile string
+		want    string
+		// cleared are keys the profile clears
+		cleared []string
+	}{
+		{"addon", AuthModeOAuth, []string{"token"}},
+		{"personal", AuthModeToken, []string{"oauthsecret_file"}},
+		{"room", AuthModeToken, nil},
+	}
+	defer viper.Reset()
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			viper.Reset()
+			viper.SetConfigType("yaml")
+			if err := viper.ReadConfig(bytes.NewBufferString(config)); err != nil {
+				t.Fatal(err)
+			}
+			viper.Set("profile", tt.profile)
+			if err := applyProfile(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range tt.cleared {
+				if value := viper.GetString(key); value != "" {
+					t.Errorf("%v = %q, want it cleared", key, value)
+				}
+			}
+			if tt.profile == "addon" && viper.GetString("oauthid") != "addon" {
+				t.Errorf("oauthid = %q, want addon", viper.GetString("oauthid"))
+			}
+			if mode, err := ConfiguredAuthMode(); err != nil || mode != tt.want {
+				t.Errorf("auth mode = %v (error: %v), want %v", mode, err, tt.want)
+			}
+		})
+	}
+}
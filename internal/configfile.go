@@ -0,0 +1,408 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// KnownKeys are the keys that may appear at the top level of the config file.
+var KnownKeys = []string{
+	"oauthid",
+	"oauthsecret",
+	"oauthsecret_file",
+	"oauthsecret_command",
+	"token",
+	"token_file",
+	"token_command",
+	"secret_backend",
+	"secrets_key_file",
+	"endpoint",
+	"proxy",
+	"connect_timeout",
+	"timeout",
+	"ca_file",
+	"client_cert",
+	"client_key",
+	"insecure_skip_verify",
+	"tls_min_version",
+	"spool",
+	"spool_max_age",
+	"spool_max_attempts",
+	"via_daemon",
+	"daemon_socket",
+	"daemon_http",
+	"daemon_queue_size",
+	"daemon_concurrency",
+	"retry_max_attempts",
+	"retry_deadline",
+	"room",
+	"groups",
+	"parallel",
+	"templates",
+	"templates_dir",
+	"debug",
+	"verbose",
+	"quiet",
+	"log_format",
+	"output",
+	"no_token_cache",
+	"default_profile",
+	"profiles",
+}
+
+// nonProfileKeys are the keys that have no effect within a profile.
+var nonProfileKeys = []string{"default_profile", "profiles"}
+
+// IsKnownKey reports if key is a valid top level key of the config file.
+func IsKnownKey(key string) bool {
+	return stringInSlice(key, KnownKeys)
+}
+
+// ConfigFile returns the config file in use, or the default location
+// if no config file was found.
+func ConfigFile() (string, error) {
+	if f := viper.ConfigFileUsed(); f != "" {
+		return f, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Could not determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".hipchat-cli.yaml"), nil
+}
+
+// ReadConfigFile parses a YAML config file, keeping the order of the keys.
+// A missing file results in an empty configuration.
+func ReadConfigFile(filename string) (yaml.MapSlice, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return yaml.MapSlice{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Could not parse config file %v: %v", filename, err)
+	}
+	return config, nil
+}
+
+// WriteConfigFile stores config in filename, readable only by the owner.
+func WriteConfigFile(filename string, config yaml.MapSlice) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, append([]byte("---\n"), data...), 0600)
+}
+
+// LookupConfigKey returns the value of a dotted key like profiles.prod.endpoint.
+func LookupConfigKey(config yaml.MapSlice, key string) (interface{}, bool) {
+	path := strings.Split(key, ".")
+	for i, item := range config {
+		if fmt.Sprint(item.Key) != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return config[i].Value, true
+		}
+		sub, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, false
+		}
+		return LookupConfigKey(sub, strings.Join(path[1:], "."))
+	}
+	return nil, false
+}
+
+// SetConfigKey sets a dotted key like profiles.prod.endpoint,
+// creating the intermediate maps as needed.
+func SetConfigKey(config yaml.MapSlice, key string, value interface{}) (yaml.MapSlice, error) {
+	path := strings.Split(key, ".")
+	for i, item := range config {
+		if fmt.Sprint(item.Key) != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			config[i].Value = value
+			return config, nil
+		}
+
+		sub, ok := item.Value.(yaml.MapSlice)
+		if !ok && item.Value != nil {
+			return nil, fmt.Errorf("%v is not a map", path[0])
+		}
+		sub, err := SetConfigKey(sub, strings.Join(path[1:], "."), value)
+		if err != nil {
+			return nil, err
+		}
+		config[i].Value = sub
+		return config, nil
+	}
+
+	if len(path) == 1 {
+		return append(config, yaml.MapItem{Key: key, Value: value}), nil
+	}
+	sub, err := SetConfigKey(yaml.MapSlice{}, strings.Join(path[1:], "."), value)
+	if err != nil {
+		return nil, err
+	}
+	return append(config, yaml.MapItem{Key: path[0], Value: sub}), nil
+}
+
+// UnsetConfigKey removes a dotted key like profiles.prod.endpoint.
+// It reports if the key was present.
+func UnsetConfigKey(config yaml.MapSlice, key string) (yaml.MapSlice, bool) {
+	path := strings.Split(key, ".")
+	for i, item := range config {
+		if fmt.Sprint(item.Key) != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return append(config[:i], config[i+1:]...), true
+		}
+
+		sub, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return config, false
+		}
+		sub, found := UnsetConfigKey(sub, strings.Join(path[1:], "."))
+		config[i].Value = sub
+		return config, found
+	}
+	return config, false
+}
+
+// ParseEndpoint validates the endpoint of the HipChat API, which must be an
+// absolute URL ending in /v2/. A missing trailing slash is added.
+func ParseEndpoint(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Could not determine endpoint URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("endpoint %q should be an absolute URL like https://hipchat.example.com/v2/", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	if !strings.HasSuffix(u.Path, "/v2/") {
+		return nil, fmt.Errorf("endpoint %q should end in /v2/", endpoint)
+	}
+	return u, nil
+}
+
+// ParseProxy validates the URL of a proxy.
+func ParseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("Could not determine proxy URL: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("proxy %q should be an absolute URL like http://proxy:3128", proxy)
+	}
+	return u, nil
+}
+
+// ConfigProblem is an issue found while validating the config file.
+type ConfigProblem struct {
+	// Fatal problems prevent hipchat-cli from working, others are warnings.
+	Fatal   bool
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	if p.Fatal {
+		return "error: " + p.Message
+	}
+	return "warning: " + p.Message
+}
+
+// ValidateConfigFile checks the config file for mistakes.
+func ValidateConfigFile(filename string) ([]ConfigProblem, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ReadConfigFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []ConfigProblem
+	warn := func(format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Message: fmt.Sprintf(format, args...)})
+	}
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Fatal: true, Message: fmt.Sprintf(format, args...)})
+	}
+
+	settings := map[string]yaml.MapSlice{"": config}
+	names := []string{""}
+	if p, ok := LookupConfigKey(config, "profiles"); ok {
+		profiles, ok := p.(yaml.MapSlice)
+		if !ok {
+			fail("profiles should be a map of profile names to settings")
+		}
+		for _, item := range profiles {
+			profile, ok := item.Value.(yaml.MapSlice)
+			if !ok {
+				fail("profile %v should be a map of settings", item.Key)
+				continue
+			}
+			settings[fmt.Sprint(item.Key)] = profile
+			names = append(names, fmt.Sprint(item.Key))
+		}
+	}
+
+	if p, ok := LookupConfigKey(config, "default_profile"); ok {
+		if _, found := settings[fmt.Sprint(p)]; !found || p == "" {
+			fail("default_profile %v is not defined in profiles", p)
+		}
+	}
+
+	hasSecrets := false
+	for _, profile := range names {
+		s := settings[profile]
+		prefix := ""
+		if profile != "" {
+			prefix = "profiles." + profile + "."
+		}
+
+		for _, item := range s {
+			key := fmt.Sprint(item.Key)
+			if !IsKnownKey(key) {
+				warn("unknown key %v%v", prefix, key)
+			} else if profile != "" && stringInSlice(key, nonProfileKeys) {
+				warn("%v%v has no effect within a profile", prefix, key)
+			}
+			if IsSecretKey(key) {
+				hasSecrets = true
+			}
+		}
+
+		if v, ok := LookupConfigKey(s, "endpoint"); ok {
+			if _, err := ParseEndpoint(fmt.Sprint(v)); err != nil {
+				fail("%vendpoint: %v", prefix, err)
+			}
+		}
+		if v, ok := LookupConfigKey(s, "output"); ok {
+			if _, _, err := ParseOutputFormat(fmt.Sprint(v)); err != nil {
+				fail("%voutput: %v", prefix, err)
+			}
+		}
+		for _, key := range []string{"connect_timeout", "timeout", "retry_deadline", "spool_max_age"} {
+			if v, ok := LookupConfigKey(s, key); ok {
+				if _, err := ParseDuration(v); err != nil {
+					fail("%v%v: %v", prefix, key, err)
+				}
+			}
+		}
+		if v, ok := LookupConfigKey(s, "tls_min_version"); ok {
+			if _, found := tlsVersions[fmt.Sprint(v)]; !found {
+				fail("%vtls_min_version %v should be 1.0, 1.1, 1.2 or 1.3", prefix, v)
+			}
+		}
+		for _, key := range secretFileKeys {
+			if v, ok := LookupConfigKey(s, key); ok {
+				info, err := os.Stat(fmt.Sprint(v))
+				if err != nil {
+					fail("%v%v: %v", prefix, key, err)
+				} else if info.Mode().Perm()&0077 != 0 {
+					warn("%v%v %v is accessible by other users (mode %v), run: chmod 600 %v", prefix, key, v, info.Mode().Perm(), v)
+				}
+			}
+		}
+		for _, key := range []string{"ca_file", "client_cert", "client_key"} {
+			if v, ok := LookupConfigKey(s, key); ok {
+				if _, err := os.Stat(fmt.Sprint(v)); err != nil {
+					fail("%v%v: %v", prefix, key, err)
+				}
+			}
+		}
+		if v, ok := LookupConfigKey(s, "secret_backend"); ok && fmt.Sprint(v) == SecretBackendFile {
+			if _, ok := LookupConfigKey(s, "secrets_key_file"); !ok && os.Getenv(EnvPrefix+"_SECRETS_PASSPHRASE") == "" {
+				warn("%vsecret_backend file keeps its key next to the secrets, set %v_SECRETS_PASSPHRASE or secrets_key_file", prefix, EnvPrefix)
+			}
+		}
+		if v, ok := LookupConfigKey(s, "insecure_skip_verify"); ok && fmt.Sprint(v) == "true" {
+			warn("%vinsecure_skip_verify is set, the certificate of the HipChat server is not verified", prefix)
+		}
+		if v, ok := LookupConfigKey(s, "proxy"); ok {
+			if _, err := ParseProxy(fmt.Sprint(v)); err != nil {
+				fail("%vproxy: %v", prefix, err)
+			}
+		}
+		if v, ok := LookupConfigKey(s, "groups"); ok {
+			if _, err := ParseGroups(v); err != nil {
+				fail("%vgroups: %v", prefix, err)
+			}
+		}
+		if v, ok := LookupConfigKey(s, "templates"); ok {
+			if _, err := ConfigTemplates(v); err != nil {
+				fail("%vtemplates: %v", prefix, err)
+			}
+		}
+	}
+
+	if !hasCredentials(settings) && viper.GetString("token") == "" && viper.GetString("oauthid") == "" {
+		fail("no credentials configured, specify a token or an oauthid and oauthsecret")
+	}
+
+	if hasSecrets && info.Mode().Perm()&0077 != 0 {
+		warn("%v contains secrets but is accessible by other users (mode %v), run: chmod 600 %v", filename, info.Mode().Perm(), filename)
+	}
+
+	return problems, nil
+}
+
+// secretFileKeys are the settings naming a file that holds a secret.
+var secretFileKeys = []string{"oauthsecret_file", "token_file", "secrets_key_file"}
+
+// genericValue converts a value read by ReadConfigFile to the maps and
+// slices used by viper.
+func genericValue(v interface{}) interface{} {
+	if slice, ok := v.(yaml.MapSlice); ok {
+		data, err := yaml.Marshal(slice)
+		if err == nil {
+			var generic interface{}
+			if yaml.Unmarshal(data, &generic) == nil {
+				v = generic
+			}
+		}
+	}
+	return stringMapValue(v)
+}
+
+// hasCredentials reports if the top level settings or any profile
+// contain a token or oauthid.
+func hasCredentials(settings map[string]yaml.MapSlice) bool {
+	for _, s := range settings {
+		for _, key := range []string{"token", "token_file", "token_command", "oauthid", "secret_backend"} {
+			if _, ok := LookupConfigKey(s, key); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/spf13/viper"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// DryRun reports if requests that change something should be printed
+// instead of sent, as set by --dry-run.
+func DryRun() bool {
+	return viper.GetBool("dry_run")
+}
+
+// dryRunRequest is a request printed by a dry run.
+type dryRunRequest struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// dryRunTransport prints requests instead of sending them.
+type dryRunTransport struct{}
+
+func (dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return nil, UsageErrorf("--dry-run only applies to requests that change something")
+	}
+
+	r := dryRunRequest{Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, bytes.TrimSpace(body), "", "  "); err == nil {
+			r.Body = indented.Bytes()
+		} else if len(body) > 0 {
+			r.Body, _ = json.Marshal(string(body))
+		}
+	}
+
+	err := PrintResult(Result{
+		Value:  r,
+		Header: []string{"METHOD", "URL"},
+		Rows:   [][]string{{r.Method, r.URL}},
+		Text: func(w io.Writer) error {
+			if _, err := fmt.Fprintln(w, r.Method, r.URL); err != nil {
+				return err
+			}
+			if len(r.Body) == 0 {
+				return nil
+			}
+			_, err := fmt.Fprintf(w, "%s\n", r.Body)
+			return err
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Responses are decoded by some services, so return an empty document.
+	// Commands should not print what is decoded from it.
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+		Request:    req,
+	}, nil
+}
+
+// dryRunClient returns a client that prints requests instead of sending
+// them, without retrieving an access token.
+func dryRunClient() (*hipchat.Client, error) {
+	Debugf("Dry run, requests are printed instead of sent")
+	c := hipchat.NewClient("dry-run")
+	c.SetHTTPClient(&http.Client{Transport: dryRunTransport{}})
+	c, err := configureEndpoint(c)
+	if err != nil {
+		return nil, UsageErrorf("Error while configuring client: %v", err)
+	}
+	return c, nil
+}
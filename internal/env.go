@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of the environment variables that set config keys,
+// the endpoint key for example is read from HIPCHAT_ENDPOINT.
+const EnvPrefix = "HIPCHAT"
+
+// envAliases are additional environment variables for config keys.
+var envAliases = map[string]string{
+	"oauthid":     EnvPrefix + "_OAUTH_ID",
+	"oauthsecret": EnvPrefix + "_OAUTH_SECRET",
+}
+
+// boundFlags are the flags that set config keys, indexed by key.
+var boundFlags = map[string]*pflag.Flag{}
+
+// ConfigPrecedence describes which source of a setting wins.
+const ConfigPrecedence = "flag > environment > profile > config file > default"
+
+// SetupEnv makes every config key available as a prefixed environment variable.
+// Dots and dashes in keys are replaced by underscores.
+func SetupEnv() {
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+
+	for key, env := range envAliases {
+		viper.BindEnv(key, env)
+	}
+}
+
+// EnvNames returns the environment variables that set key.
+func EnvNames(key string) []string {
+	names := []string{EnvPrefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))}
+	if alias, ok := envAliases[key]; ok {
+		names = append(names, alias)
+	}
+	return names
+}
+
+// BindFlag makes flag set the config key, overriding all other sources.
+func BindFlag(key string, flag *pflag.Flag) {
+	viper.BindPFlag(key, flag)
+	boundFlags[key] = flag
+}
+
+// ConfigSource returns where the value of key comes from.
+func ConfigSource(key string) string {
+	if flag, ok := boundFlags[key]; ok && flag.Changed {
+		return "flag --" + flag.Name
+	}
+	for _, env := range EnvNames(key) {
+		if os.Getenv(env) != "" {
+			return "environment " + env
+		}
+	}
+	if name := ActiveProfile(); name != "" {
+		if _, ok := Profiles()[name][key]; ok {
+			return "profile " + name
+		}
+	}
+	if viper.InConfig(key) {
+		return "config file"
+	}
+	if viper.IsSet(key) {
+		return "default"
+	}
+	return "unset"
+}
+
+// LogConfigSources logs the value and source of every known config key.
+func LogConfigSources() {
+	if !DebugEnabled() {
+		return
+	}
+	Debugf("Configuration precedence: %v", ConfigPrecedence)
+
+	keys := append([]string{}, KnownKeys...)
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "profiles" {
+			continue
+		}
+		source := ConfigSource(key)
+		if source == "unset" {
+			continue
+		}
+
+		value := cast.ToString(viper.Get(key))
+		if IsSecretKey(key) {
+			value = MaskSecret(value)
+		}
+		Debugf("  %v = %q (%v)", key, value, source)
+	}
+}
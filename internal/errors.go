@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Exit codes of hipchat-cli, documented in the README.
+const (
+	ExitOK         = 0
+	ExitError      = 1 // any error not listed below
+	ExitUsage      = 2 // invalid flags, arguments or settings
+	ExitAuth       = 3 // missing or invalid credentials
+	ExitPermission = 4 // the credentials lack a required scope or permission
+	ExitNotFound   = 5 // the room, user or other resource does not exist
+	ExitRateLimit  = 6 // HipChat rejected the request because of its rate limit
+	ExitServer     = 7 // HipChat failed to handle the request
+	ExitNetwork    = 8 // HipChat could not be reached
+	ExitPartial    = 9 // sending to some of the rooms or users failed
+)
+
+// APIError is an error response of the HipChat API.
+type APIError struct {
+	StatusCode int
+	// Type and Message are taken from the error document in the response, if any.
+	Type    string
+	Message string
+	// Hint explains how the error may be resolved.
+	Hint string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("Server returns status %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Hint != "" {
+		msg += "\n" + e.Hint
+	}
+	return msg
+}
+
+// ExitCode returns the exit code for the status of the response.
+func (e *APIError) ExitCode() int {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ExitAuth
+	case e.StatusCode == http.StatusForbidden:
+		return ExitPermission
+	case e.StatusCode == http.StatusNotFound:
+		return ExitNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ExitRateLimit
+	case e.StatusCode >= 500:
+		return ExitServer
+	default:
+		return ExitError
+	}
+}
+
+// NewAPIError returns the error for a failed response, parsing the error
+// document HipChat sends along with it. The body of resp remains readable.
+func NewAPIError(resp *http.Response) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode}
+	if doc := errorDocument(resp); doc != nil {
+		e.Type = doc.Type
+		e.Message = doc.Message
+	}
+	return e
+}
+
+// codedError is an error with a specific exit code.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// WithExitCode returns err with the given exit code.
+func WithExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code, err}
+}
+
+// UsageErrorf returns an error for invalid flags, arguments or settings.
+func UsageErrorf(format string, args ...interface{}) error {
+	return WithExitCode(ExitUsage, fmt.Errorf(format, args...))
+}
+
+// ExitCode returns the exit code hipchat-cli exits with for err.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ExitCode()
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return ExitNetwork
+	}
+	return ExitError
+}
+
+// IsTransient reports if err may not occur when the request is sent again later.
+func IsTransient(err error) bool {
+	switch ExitCode(err) {
+	case ExitNetwork, ExitServer, ExitRateLimit:
+		return true
+	}
+	return false
+}
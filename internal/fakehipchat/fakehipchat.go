@@ -0,0 +1,737 @@
+// Package fakehipchat implements an in-memory HipChat API v2 server, so
+// hipchat-cli can be tested end-to-end without a HipChat server by pointing
+// its endpoint at it.
+package fakehipchat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nu7hatch/gouuid"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// Prefix is the path under which the API is served.
+const Prefix = "/v2/"
+
+// tokenLifetime is the lifetime of the access tokens handed out.
+const tokenLifetime = time.Hour
+
+// Request is a request received by the server.
+type Request struct {
+	Time   time.Time       `json:"time"`
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Query  string          `json:"query,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Status int             `json:"status"`
+}
+
+// AllScopes are all scopes known to HipChat.
+var AllScopes = []string{
+	hipchat.ScopeAdminGroup, hipchat.ScopeAdminRoom, hipchat.ScopeImportData, hipchat.ScopeManageRooms,
+	hipchat.ScopeSendMessage, hipchat.ScopeSendNotification, hipchat.ScopeViewGroup,
+	hipchat.ScopeViewMessages, hipchat.ScopeViewRoom,
+}
+
+// Server is a fake HipChat server. It accepts any client credentials unless
+// OAuthID and OAuthSecret are set, and grants all requested scopes unless
+// Scopes is set. Requests need an access token it handed out or a token
+// added with AddToken, with a scope the resource requires.
+type Server struct {
+	// OAuthID and OAuthSecret are the only credentials accepted, if set.
+	OAuthID     string
+	OAuthSecret string
+	// Scopes are the scopes that can be granted to access tokens, if set.
+	Scopes []string
+	// Log is called for every request, if set.
+	Log func(Request)
+
+	mu       sync.Mutex
+	rooms    []*room
+	users    []*user
+	tokens   map[string][]string
+	requests []Request
+	failures []failure
+	nextID   int
+}
+
+type user struct {
+	hipchat.User
+	messages []hipchat.Message
+}
+
+type room struct {
+	hipchat.Room
+	messages []hipchat.Message
+	webhooks []hipchat.Webhook
+}
+
+// failure makes requests fail with a status, see Fail.
+type failure struct {
+	path   string
+	status int
+	count  int
+}
+
+// New returns an empty server.
+func New() *Server {
+	return &Server{tokens: map[string][]string{}, nextID: 1}
+}
+
+// AddRoom creates a room.
+func (s *Server) AddRoom(name, topic string) hipchat.Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addRoom(name, topic, "public").Room
+}
+
+// AddUser creates a user.
+func (s *Server) AddUser(name, mentionName, email string) hipchat.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := &user{User: hipchat.User{
+		ID:          s.id(),
+		Name:        name,
+		MentionName: mentionName,
+		Email:       email,
+		Created:     timestamp(time.Now()),
+		Timezone:    "UTC",
+		Presence:    hipchat.UserPresence{Show: hipchat.UserPresenceShowChat, IsOnline: true},
+	}}
+	s.users = append(s.users, u)
+	return u.User
+}
+
+// AddMessage adds a message from a user to the history of a room, as if
+// it was sent at date.
+func (s *Server) AddMessage(roomName, from, message string, date time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.findRoom(roomName)
+	if r == nil {
+		return fmt.Errorf("room %v does not exist", roomName)
+	}
+	r.messages = append(r.messages, newMessage("message", map[string]interface{}{"name": from, "mention_name": from}, message, "text", date))
+	return nil
+}
+
+// AddToken accepts token as personal or room API token with scopes.
+func (s *Server) AddToken(token string, scopes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = scopes
+}
+
+// RevokeTokens revokes all access tokens handed out and tokens added so far.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string][]string{}
+}
+
+// Fail makes the next count requests whose path contains path fail with status.
+func (s *Server) Fail(path string, status, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{path, status, count})
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Messages returns the messages and notifications sent to a room, oldest first.
+func (s *Server) Messages(roomName string) []hipchat.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.findRoom(roomName); r != nil {
+		return append([]hipchat.Message{}, r.messages...)
+	}
+	return nil
+}
+
+// PrivateMessages returns the private messages sent to a user, given by
+// ID, email or @mention name, oldest first.
+func (s *Server) PrivateMessages(id string) []hipchat.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u := s.findUser(id); u != nil {
+		return append([]hipchat.Message{}, u.messages...)
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+	s.mu.Lock()
+	s.serve(rec, req, body)
+	entry := Request{Time: time.Now(), Method: req.Method, Path: req.URL.Path, Query: req.URL.RawQuery, Status: rec.status}
+	if json.Valid(body) {
+		entry.Body = body
+	} else if len(body) > 0 {
+		entry.Body, _ = json.Marshal(string(body))
+	}
+	s.requests = append(s.requests, entry)
+	s.mu.Unlock()
+
+	if s.Log != nil {
+		s.Log(entry)
+	}
+}
+
+// recorder keeps the status of a response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// serve handles a request, with s.mu held.
+func (s *Server) serve(w http.ResponseWriter, req *http.Request, body []byte) {
+	if req.URL.Path == "/_fake/requests" {
+		writeJSON(w, http.StatusOK, s.requests)
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, Prefix) {
+		writeError(w, http.StatusNotFound, "Not found, the API is served under "+Prefix)
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.EscapedPath(), Prefix), "/"), "/")
+	for i := range path {
+		path[i], _ = url.PathUnescape(path[i])
+	}
+
+	for i, f := range s.failures {
+		if f.count > 0 && strings.Contains(req.URL.Path, f.path) {
+			s.failures[i].count--
+			writeError(w, f.status, "Failure injected by the fake server")
+			return
+		}
+	}
+
+	if len(path) == 2 && path[0] == "oauth" && path[1] == "token" && req.Method == http.MethodPost {
+		s.generateToken(w, req, body)
+		return
+	}
+	granted, ok := s.tokens[strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth session")
+		return
+	}
+	if scopes := requiredScopes(req.Method, path); !anyOf(granted, scopes) {
+		writeError(w, http.StatusForbidden, "This endpoint requires one of the scopes: "+strings.Join(scopes, ", "))
+		return
+	}
+
+	switch {
+	case path[0] == "room":
+		s.serveRoom(w, req, path[1:], body)
+	case path[0] == "user":
+		s.serveUser(w, req, path[1:], body)
+	default:
+		writeError(w, http.StatusNotFound, "Unknown resource "+req.URL.Path)
+	}
+}
+
+func (s *Server) generateToken(w http.ResponseWriter, req *http.Request, body []byte) {
+	id, secret, ok := req.BasicAuth()
+	if !ok || id == "" || (s.OAuthID != "" && (id != s.OAuthID || secret != s.OAuthSecret)) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Invalid OAuth client credentials")
+		return
+	}
+
+	form, _ := url.ParseQuery(string(body))
+	scopes := strings.Fields(form.Get("scope"))
+	if s.Scopes != nil {
+		for _, scope := range scopes {
+			if !contains(s.Scopes, scope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "Invalid scope: "+scope)
+				return
+			}
+		}
+	}
+
+	u, _ := uuid.NewV4()
+	token := strings.Replace(u.String(), "-", "", -1)
+	s.tokens[token] = scopes
+	writeJSON(w, http.StatusOK, hipchat.OAuthAccessToken{
+		AccessToken: token,
+		ExpiresIn:   uint32(tokenLifetime / time.Second),
+		GroupID:     1,
+		GroupName:   "fake",
+		Scope:       strings.Join(scopes, " "),
+		TokenType:   "bearer",
+	})
+}
+
+// requiredScopes returns the scopes of which a token needs one to access
+// the resource at path with method.
+func requiredScopes(method string, path []string) []string {
+	var action string
+	if len(path) > 2 {
+		action = strings.Join(path[2:], "/")
+	}
+	switch {
+	case path[0] == "user" && action == "message":
+		return []string{hipchat.ScopeSendMessage}
+	case path[0] == "user":
+		return []string{hipchat.ScopeViewGroup}
+	case action == "notification":
+		return []string{hipchat.ScopeSendNotification}
+	case action == "message":
+		return []string{hipchat.ScopeSendMessage}
+	case strings.HasPrefix(action, "history"):
+		return []string{hipchat.ScopeViewMessages}
+	case action == "topic" || strings.HasPrefix(action, "webhook"):
+		return []string{hipchat.ScopeAdminRoom}
+	case method == http.MethodGet:
+		return []string{hipchat.ScopeViewGroup, hipchat.ScopeViewRoom}
+	case method == http.MethodPut:
+		return []string{hipchat.ScopeAdminRoom, hipchat.ScopeManageRooms}
+	default:
+		// Creating and deleting rooms
+		return []string{hipchat.ScopeManageRooms}
+	}
+}
+
+func (s *Server) serveRoom(w http.ResponseWriter, req *http.Request, path []string, body []byte) {
+	if len(path) == 0 || path[0] == "" {
+		switch req.Method {
+		case http.MethodGet:
+			s.listRooms(w, req)
+		case http.MethodPost:
+			var create hipchat.CreateRoomRequest
+			if !decode(w, body, &create) {
+				return
+			}
+			if create.Name == "" {
+				writeError(w, http.StatusBadRequest, "Room name is required")
+				return
+			}
+			if s.findRoom(create.Name) != nil {
+				writeError(w, http.StatusConflict, "Another room exists with that name")
+				return
+			}
+			if create.Privacy == "" {
+				create.Privacy = "public"
+			}
+			r := s.addRoom(create.Name, create.Topic, create.Privacy)
+			r.IsGuestAccessible = create.GuestAccess
+			writeJSON(w, http.StatusCreated, struct {
+				ID    int           `json:"id"`
+				Links hipchat.Links `json:"links"`
+			}{r.ID, r.Links.Links})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	r := s.findRoom(path[0])
+	if r == nil {
+		writeError(w, http.StatusNotFound, "Room not found")
+		return
+	}
+
+	action := strings.Join(path[1:], "/")
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		// hipchat.Room misspells is_guest_accessible
+		writeJSON(w, http.StatusOK, struct {
+			hipchat.Room
+			IsGuestAccessible bool `json:"is_guest_accessible"`
+		}{r.Room, r.IsGuestAccessible})
+	case action == "" && req.Method == http.MethodPut:
+		var update hipchat.UpdateRoomRequest
+		if !decode(w, body, &update) {
+			return
+		}
+		r.Name, r.Topic, r.Privacy = update.Name, update.Topic, update.Privacy
+		r.IsArchived, r.IsGuestAccessible = update.IsArchived, update.IsGuestAccess
+		if owner := s.findUser(update.Owner.ID); owner != nil {
+			r.Owner = owner.User
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" && req.Method == http.MethodDelete:
+		for i, other := range s.rooms {
+			if other == r {
+				s.rooms = append(s.rooms[:i], s.rooms[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "statistics" && req.Method == http.MethodGet:
+		stats := hipchat.RoomStatistics{MessagesSent: len(r.messages)}
+		if len(r.messages) > 0 {
+			stats.LastActive = r.messages[len(r.messages)-1].Date
+		}
+		writeJSON(w, http.StatusOK, stats)
+	case action == "topic" && req.Method == http.MethodPut:
+		var topic hipchat.SetTopicRequest
+		if !decode(w, body, &topic) {
+			return
+		}
+		r.Topic = topic.Topic
+		w.WriteHeader(http.StatusNoContent)
+	case action == "notification" && req.Method == http.MethodPost:
+		var n hipchat.NotificationRequest
+		if !decode(w, body, &n) {
+			return
+		}
+		if n.Message == "" {
+			writeError(w, http.StatusBadRequest, "message is required")
+			return
+		}
+		if utf8.RuneCountInString(n.Message) > 10000 {
+			writeError(w, http.StatusBadRequest, "message is longer than 10000 characters")
+			return
+		}
+		format := n.MessageFormat
+		if format == "" {
+			format = "html"
+		}
+		r.messages = append(r.messages, newMessage("notification", n.From, n.Message, format, time.Now()))
+		w.WriteHeader(http.StatusNoContent)
+	case action == "message" && req.Method == http.MethodPost:
+		var m hipchat.RoomMessageRequest
+		if !decode(w, body, &m) {
+			return
+		}
+		if m.Message == "" {
+			writeError(w, http.StatusBadRequest, "message is required")
+			return
+		}
+		msg := newMessage("message", map[string]interface{}{"name": "Fake User", "mention_name": "fake"}, m.Message, "text", time.Now())
+		r.messages = append(r.messages, msg)
+		writeJSON(w, http.StatusCreated, map[string]string{"id": msg.ID, "timestamp": msg.Date})
+	case action == "history" && req.Method == http.MethodGet:
+		s.history(w, req, r)
+	case action == "history/latest" && req.Method == http.MethodGet:
+		s.latest(w, req, r)
+	case action == "webhook" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, hipchat.WebhookList{Webhooks: r.webhooks, MaxResults: 100})
+	case action == "webhook" && req.Method == http.MethodPost:
+		var create hipchat.CreateWebhookRequest
+		if !decode(w, body, &create) {
+			return
+		}
+		wh := hipchat.Webhook{ID: s.id(), Name: create.Name, Key: create.Key, Event: create.Event, Pattern: create.Pattern, URL: create.URL}
+		r.webhooks = append(r.webhooks, wh)
+		writeJSON(w, http.StatusCreated, wh)
+	case len(path) == 3 && path[1] == "webhook" && req.Method == http.MethodDelete:
+		for i, wh := range r.webhooks {
+			if strconv.Itoa(wh.ID) == path[2] {
+				r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "Webhook not found")
+	default:
+		writeError(w, http.StatusNotFound, "Unknown resource "+req.URL.Path)
+	}
+}
+
+func (s *Server) listRooms(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	includeArchived := q.Get("include-archived") == "true"
+	includePrivate := q.Get("include-private") != "false"
+
+	var rooms []hipchat.Room
+	for _, r := range s.rooms {
+		if (r.IsArchived && !includeArchived) || (r.Privacy == "private" && !includePrivate) {
+			continue
+		}
+		rooms = append(rooms, r.Room)
+	}
+
+	start, max := pagination(q, 100)
+	list := hipchat.Rooms{StartIndex: start, MaxResults: max, Items: []hipchat.Room{}}
+	if start < len(rooms) {
+		end := start + max
+		if end < len(rooms) {
+			list.Links.Next = fmt.Sprintf("%vroom?start-index=%d&max-results=%d", Prefix, end, max)
+		} else {
+			end = len(rooms)
+		}
+		list.Items = rooms[start:end]
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// history returns the messages before date and after end-date, newest
+// first for paging, in the order requested by reverse.
+func (s *Server) history(w http.ResponseWriter, req *http.Request, r *room) {
+	q := req.URL.Query()
+	until, since := time.Now(), time.Time{}
+	if d := q.Get("date"); d != "" && d != "recent" {
+		t, err := parseDate(d)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid date: "+d)
+			return
+		}
+		until = t
+	}
+	if d := q.Get("end-date"); d != "" {
+		t, err := parseDate(d)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid end-date: "+d)
+			return
+		}
+		since = t
+	}
+
+	var messages []hipchat.Message
+	for i := len(r.messages) - 1; i >= 0; i-- {
+		t, _ := parseDate(r.messages[i].Date)
+		if !t.After(until) && !t.Before(since) {
+			messages = append(messages, r.messages[i])
+		}
+	}
+
+	start, max := pagination(q, 100)
+	history := hipchat.History{StartIndex: start, MaxResults: max, Items: []hipchat.Message{}}
+	if start < len(messages) {
+		end := start + max
+		if end < len(messages) {
+			next := url.Values{}
+			for k, v := range q {
+				next[k] = v
+			}
+			next.Set("start-index", strconv.Itoa(end))
+			history.Links.Next = Prefix + "room/" + url.PathEscape(r.Name) + "/history?" + next.Encode()
+		} else {
+			end = len(messages)
+		}
+		history.Items = messages[start:end]
+	}
+	if q.Get("reverse") != "false" {
+		reverse(history.Items)
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// latest returns the most recent messages, oldest first.
+func (s *Server) latest(w http.ResponseWriter, req *http.Request, r *room) {
+	q := req.URL.Query()
+	_, max := pagination(q, 75)
+
+	messages := r.messages
+	if id := q.Get("not-before"); id != "" {
+		for i, m := range messages {
+			if m.ID == id {
+				messages = messages[i:]
+				break
+			}
+		}
+	}
+	if len(messages) > max {
+		messages = messages[len(messages)-max:]
+	}
+	writeJSON(w, http.StatusOK, hipchat.History{MaxResults: max, Items: append([]hipchat.Message{}, messages...)})
+}
+
+func (s *Server) serveUser(w http.ResponseWriter, req *http.Request, path []string, body []byte) {
+	if len(path) == 0 || path[0] == "" {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		start, max := pagination(req.URL.Query(), 100)
+		users := hipchat.Users{StartIndex: start, MaxResults: max, Items: []hipchat.User{}}
+		for i := start; i < len(s.users) && i < start+max; i++ {
+			users.Items = append(users.Items, s.users[i].User)
+		}
+		writeJSON(w, http.StatusOK, users)
+		return
+	}
+
+	u := s.findUser(path[0])
+	if u == nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	action := strings.Join(path[1:], "/")
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, u.User)
+	case action == "message" && req.Method == http.MethodPost:
+		var m hipchat.MessageRequest
+		if !decode(w, body, &m) {
+			return
+		}
+		if m.Message == "" {
+			writeError(w, http.StatusBadRequest, "message is required")
+			return
+		}
+		format := m.MessageFormat
+		if format == "" {
+			format = "text"
+		}
+		u.messages = append(u.messages, newMessage("message", map[string]interface{}{"name": "Fake User", "mention_name": "fake"}, m.Message, format, time.Now()))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "Unknown resource "+req.URL.Path)
+	}
+}
+
+func (s *Server) addRoom(name, topic, privacy string) *room {
+	id := s.id()
+	r := &room{Room: hipchat.Room{
+		ID:      id,
+		Name:    name,
+		Topic:   topic,
+		Privacy: privacy,
+		Created: timestamp(time.Now()),
+		XmppJid: fmt.Sprintf("1_%v@conf.fake", strings.ToLower(strings.Replace(name, " ", "_", -1))),
+		Links:   hipchat.RoomLinks{Links: hipchat.Links{Self: fmt.Sprintf("%vroom/%d", Prefix, id)}},
+	}}
+	s.rooms = append(s.rooms, r)
+	return r
+}
+
+// findRoom returns the room with the id or name.
+func (s *Server) findRoom(idOrName string) *room {
+	for _, r := range s.rooms {
+		if strconv.Itoa(r.ID) == idOrName || strings.EqualFold(r.Name, idOrName) {
+			return r
+		}
+	}
+	return nil
+}
+
+// findUser returns the user with the id, email or @mention name.
+func (s *Server) findUser(id string) *user {
+	for _, u := range s.users {
+		if strconv.Itoa(u.ID) == id || strings.EqualFold(u.Email, id) || "@"+u.MentionName == id {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) id() int {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+func newMessage(kind string, from interface{}, message, format string, date time.Time) hipchat.Message {
+	u, _ := uuid.NewV4()
+	return hipchat.Message{
+		ID:            u.String(),
+		Date:          timestamp(date),
+		From:          from,
+		Message:       message,
+		MessageFormat: format,
+		Type:          kind,
+		Mentions:      []hipchat.User{},
+	}
+}
+
+// timestamp formats t like HipChat does.
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000-07:00")
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func pagination(q url.Values, defaultMax int) (start, max int) {
+	start, _ = strconv.Atoi(q.Get("start-index"))
+	max, _ = strconv.Atoi(q.Get("max-results"))
+	if start < 0 {
+		start = 0
+	}
+	if max <= 0 {
+		max = defaultMax
+	}
+	if max > 1000 {
+		max = 1000
+	}
+	return start, max
+}
+
+func reverse(messages []hipchat.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+func decode(w http.ResponseWriter, body []byte, v interface{}) bool {
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with an error document like HipChat does.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "message": message, "type": http.StatusText(status)},
+	})
+}
+
+// writeOAuthError responds with an OAuth 2.0 error document, as the token
+// endpoint does.
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// anyOf reports if list contains any of values.
+func anyOf(list, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,4 @@
 package internal
 
-//DebugLogging indicates if the user requested debug information.
-//it functions as a switch for extra logging
-var DebugLogging bool
+// NoTokenCache disables reading and writing of the OAuth access token cache.
+var NoTokenCache bool
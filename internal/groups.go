@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Groups returns the groups of rooms defined by the groups key of the
+// config file, indexed by name.
+func Groups() (map[string][]string, error) {
+	return ParseGroups(viper.Get("groups"))
+}
+
+// ParseGroups returns the groups defined by the value of the groups key.
+func ParseGroups(value interface{}) (map[string][]string, error) {
+	groups := map[string][]string{}
+	if value == nil {
+		return groups, nil
+	}
+	generic, err := cast.ToStringMapE(genericValue(value))
+	if err != nil {
+		return nil, fmt.Errorf("groups should be a map of group names to lists of rooms")
+	}
+	for name, v := range generic {
+		rooms, err := cast.ToStringSliceE(v)
+		if err != nil || len(rooms) == 0 {
+			return nil, fmt.Errorf("group %v should be a list of rooms", name)
+		}
+		groups[name] = rooms
+	}
+	return groups, nil
+}
+
+// SplitRooms returns the rooms in value, a comma separated list of rooms
+// and groups, with the groups replaced by their rooms. Groups may include
+// other groups. Every room is returned once, in the order it is listed.
+func SplitRooms(value string) ([]string, error) {
+	groups, err := Groups()
+	if err != nil {
+		return nil, err
+	}
+
+	var rooms []string
+	seen := map[string]bool{}
+	var expand func(names []string, parents []string) error
+	expand = func(names []string, parents []string) error {
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if members, isGroup := groups[name]; isGroup {
+				if stringInSlice(name, parents) {
+					return fmt.Errorf("group %v includes itself", name)
+				}
+				if err := expand(members, append(parents, name)); err != nil {
+					return err
+				}
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				rooms = append(rooms, name)
+			}
+		}
+		return nil
+	}
+	if err := expand(strings.Split(value, ","), nil); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    map[string][]string
+		wantErr bool
+	}{
+		{"unset", nil, map[string][]string{}, false},
+		{"yaml", map[interface{}]interface{}{"ops": []interface{}{"ops", "sre"}}, map[string][]string{"ops": {"ops", "sre"}}, false},
+		{"json", map[string]interface{}{"all": []interface{}{"ops", "dev"}}, map[string][]string{"all": {"ops", "dev"}}, false},
+		{"not a map", []interface{}{"ops"}, nil, true},
+		{"empty group", map[string]interface{}{"ops": []interface{}{}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseGroups(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGroups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRooms(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  interface{}
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"single room", nil, "ops", []string{"ops"}, false},
+		{"list", nil, " ops, sre,,dev ", []string{"ops", "sre", "dev"}, false},
+		{"duplicates", nil, "ops,sre,ops", []string{"ops", "sre"}, false},
+		{"group", map[string]interface{}{"oncall": []interface{}{"ops", "sre"}}, "dev,oncall", []string{"dev", "ops", "sre"}, false},
+		{"nested groups", map[string]interface{}{
+			"all":    []interface{}{"dev", "oncall"},
+			"oncall": []interface{}{"ops", "sre"},
+		}, "all,ops", []string{"dev", "ops", "sre"}, false},
+		{"cycle", map[string]interface{}{
+			"a": []interface{}{"b"},
+			"b": []interface{}{"a"},
+		}, "a", nil, true},
+		{"invalid groups", "ops", "ops", nil, true},
+	}
+	defer viper.Set("groups", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("groups", tt.groups)
+			got, err := SplitRooms(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitRooms(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitRooms(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,88 +1,361 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
 
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/tbruyelle/hipchat-go/hipchat"
 )
 
-//GetClient return a hipchat client
-//it used oauthid and oauthsecret to retrieve a temporary access token
-//It also listens to proxy and enpoint configuration in the configfile.
-func GetClient() (*hipchat.Client, error) {
+// Authentication modes used by GetClient.
+const (
+	// AuthModeToken uses a personal or room API token from the token key.
+	AuthModeToken = "token"
+	// AuthModeOAuth uses the oauthid and oauthsecret to retrieve an access token.
+	AuthModeOAuth = "oauth"
+)
 
-	oauthID := viper.GetString("oauthid")
-	if oauthID == "" {
-		return nil, fmt.Errorf("Specify an oauthid in the config file")
+// The state of the client returned by the last call to GetClient, guarded
+// by stateMu as the daemon calls GetClient while other requests are checked.
+var (
+	stateMu sync.Mutex
+	// authMode is the authentication mode selected.
+	authMode string
+	// requiredScopes are the scopes the client was requested for.
+	requiredScopes []string
+	// tokenExpiry is the expiry of the access token of the client.
+	tokenExpiry time.Time
+	// tokenKey is the token cache key of the access token of the client.
+	tokenKey string
+)
+
+// AuthMode returns the authentication mode selected by the last call to GetClient.
+func AuthMode() string {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return authMode
+}
+
+// TokenExpiry returns when the access token of the client returned by the
+// last call to GetClient expires. It is zero if the token does not expire.
+func TokenExpiry() time.Time {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return tokenExpiry
+}
+
+// ForgetToken removes the access token of the client returned by the last
+// call to GetClient from the token cache, so the next call to GetClient
+// requests a new one. Used when HipChat refused the token.
+func ForgetToken() {
+	stateMu.Lock()
+	key := tokenKey
+	stateMu.Unlock()
+	if key != "" {
+		Debugf("Removing refused access token from the token cache")
+		forgetToken(key)
 	}
+}
 
-	oauthSecret := viper.GetString("oauthsecret")
-	if oauthSecret == "" {
-		return nil, fmt.Errorf("Specify an oauthsecret in the config file")
+// setClientState records the state of the client returned by GetClient.
+func setClientState(mode string, scopes []string, expiry time.Time, key string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	authMode, requiredScopes, tokenExpiry, tokenKey = mode, scopes, expiry, key
+}
+
+// ConfiguredAuthMode returns the authentication mode GetClient would select,
+// without requesting an access token.
+func ConfiguredAuthMode() (string, error) {
+	token, err := ResolveSecret("token")
+	if err != nil {
+		return "", WithExitCode(ExitAuth, err)
+	}
+	if token != "" {
+		return AuthModeToken, nil
+	}
+	return AuthModeOAuth, nil
+}
+
+// GetClient return a hipchat client that is authorized for scopes.
+// Secrets are read as described by ResolveSecret.
+// If a token is configured it is used as is, otherwise
+// it used oauthid and oauthsecret to retrieve a temporary access token
+// which is cached until shortly before it expires, unless NoTokenCache is set.
+// It also listens to proxy and enpoint configuration in the configfile.
+// With DryRun the client prints requests instead of sending them.
+func GetClient(scopes ...string) (*hipchat.Client, error) {
+	setClientState("", scopes, time.Time{}, "")
+	if configErr != nil {
+		return nil, WithExitCode(ExitUsage, configErr)
+	}
+	if DryRun() {
+		return dryRunClient()
 	}
 
 	httpclient, err := configDefaultHTTPClient()
 	if err != nil {
-		return nil, fmt.Errorf("Error while configuring httpclient: %v", err)
+		return nil, UsageErrorf("Error while configuring httpclient: %v", err)
+	}
+
+	token, err := ResolveSecret("token")
+	if err != nil {
+		return nil, WithExitCode(ExitAuth, err)
+	}
+	if token != "" {
+		Debugf("Using auth mode: token")
+		setClientState(AuthModeToken, scopes, time.Time{}, "")
+
+		c := hipchat.NewClient(token)
+		c.SetHTTPClient(httpclient)
+		c, err = configureEndpoint(c)
+		if err != nil {
+			return nil, UsageErrorf("Error while configuring client: %v", err)
+		}
+		return c, nil
+	}
+
+	oauthID := viper.GetString("oauthid")
+	if oauthID == "" {
+		return nil, WithExitCode(ExitAuth, fmt.Errorf("Specify a token or an oauthid in the config file"))
+	}
+
+	oauthSecret, err := ResolveSecret("oauthsecret")
+	if err != nil {
+		return nil, WithExitCode(ExitAuth, err)
 	}
+	if oauthSecret == "" {
+		return nil, WithExitCode(ExitAuth, fmt.Errorf("Specify an oauthsecret, oauthsecret_file or oauthsecret_command in the config file, or store it with: hipchat-cli config set-secret"))
+	}
+
+	Debugf("Using auth mode: oauth")
+	setClientState(AuthModeOAuth, scopes, time.Time{}, "")
 
-	scope := []string{"admin_room", "view_room", "send_notification"}
 	c := hipchat.NewClient("")
 	c.SetHTTPClient(httpclient)
 	c, err = configureEndpoint(c)
 	if err != nil {
-		return nil, fmt.Errorf("Error while configuring endpoint: %v", err)
+		return nil, UsageErrorf("Error while configuring endpoint: %v", err)
 	}
 
-	token, resp, err := c.GenerateToken(hipchat.ClientCredentials{oauthID, oauthSecret}, scope)
-	if resp != nil {
-		Debug(httputil.DumpResponse(resp, true))
-	}
+	key := tokenCacheKey(oauthID, c.BaseURL.String(), scopes)
+	accessToken, expiry, err := getToken(c, key, hipchat.ClientCredentials{ClientID: oauthID, ClientSecret: oauthSecret}, scopes)
 	if err != nil {
-		return nil, fmt.Errorf("Error while retrieving oath token: %v", err)
+		return nil, err
 	}
+	setClientState(AuthModeOAuth, scopes, expiry, key)
 
-	c = token.CreateClient()
+	c = accessToken.CreateClient()
 	c.SetHTTPClient(httpclient)
 	c, err = configureEndpoint(c)
 	if err != nil {
-		return nil, fmt.Errorf("Error while configuring client: %v", err)
+		return nil, UsageErrorf("Error while configuring client: %v", err)
 	}
 	return c, nil
 }
 
-func configDefaultHTTPClient() (*http.Client, error) {
-	proxy := viper.GetString("proxy")
-	if proxy != "" {
-		proxyURL, err := url.Parse(proxy)
-		if err != nil {
-			return nil, fmt.Errorf("Could not determine proxy URL: %v", err)
+// getToken returns the cached access token for key, or generates and caches
+// a new one for the credentials and scopes, along with its expiry.
+func getToken(c *hipchat.Client, key string, credentials hipchat.ClientCredentials, scope []string) (*hipchat.OAuthAccessToken, time.Time, error) {
+	if !NoTokenCache {
+		if cached := cachedToken(key); cached != nil {
+			Debugf("Using cached access token, expires at %v", cached.ExpiresAt.Format(time.RFC3339))
+			return &cached.Token, cached.ExpiresAt, nil
 		}
+	}
+
+	obtained := time.Now()
+	token, resp, err := generateToken(c, credentials, scope)
+	if resp != nil {
+		DebugDump(httputil.DumpResponse(resp, true))
+	}
+	if err != nil {
+		return nil, time.Time{}, tokenError(resp, err, scope)
+	}
+	if err := checkGrantedScopes(token, scope); err != nil {
+		return nil, time.Time{}, WithExitCode(ExitPermission, err)
+	}
+	expiry := obtained.Add(time.Duration(token.ExpiresIn) * time.Second)
 
-		if DebugLogging {
-			fmt.Println("Using proxy: ", proxyURL.String())
+	if !NoTokenCache {
+		storeToken(key, CachedToken{
+			OAuthID:    credentials.ClientID,
+			Endpoint:   c.BaseURL.String(),
+			Scopes:     scope,
+			Token:      *token,
+			ObtainedAt: obtained,
+			ExpiresAt:  expiry,
+		})
+	}
+	return token, expiry, nil
+}
+
+// generateToken requests an access token like hipchat.Client.GenerateToken,
+// but leaves the body of a failed response readable for tokenError.
+func generateToken(c *hipchat.Client, credentials hipchat.ClientCredentials, scope []string) (*hipchat.OAuthAccessToken, *http.Response, error) {
+	rel, err := url.Parse("oauth/token")
+	if err != nil {
+		return nil, nil, err
+	}
+	params := url.Values{"grant_type": {"client_credentials"}, "scope": {strings.Join(scope, " ")}}
+	req, err := http.NewRequest("POST", c.BaseURL.ResolveReference(rel).String(), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.SetBasicAuth(credentials.ClientID, credentials.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token hipchat.OAuthAccessToken
+	resp, err := c.Do(req, &token)
+	if err != nil {
+		return nil, resp, err
+	}
+	return &token, resp, nil
+}
+
+// oauthError is the error document of the OAuth token endpoint, see RFC 6749 section 5.2.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// tokenError explains why no access token could be retrieved.
+func tokenError(resp *http.Response, err error, scope []string) error {
+	if resp == nil || resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return fmt.Errorf("Error while retrieving oath token: %w", err)
+	}
+
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	code := ExitAuth
+	body, _ := ioutil.ReadAll(resp.Body)
+	var doc oauthError
+	if json.Unmarshal(body, &doc) == nil && doc.Error != "" {
+		apiErr.Type, apiErr.Message = doc.Error, doc.Description
+		if doc.Error == "invalid_scope" {
+			code = ExitPermission
+			apiErr.Hint = fmt.Sprintf("This command needs scope(s): %v, check the scopes granted to your add-on", strings.Join(scope, ", "))
 		}
+	} else if doc := parseErrorDocument(body); doc != nil {
+		apiErr.Type, apiErr.Message = doc.Type, doc.Message
+	}
+
+	switch apiErr.ExitCode() {
+	case ExitRateLimit, ExitServer:
+		code = apiErr.ExitCode()
+	}
+	return WithExitCode(code, fmt.Errorf("Error while retrieving oath token: %w", apiErr))
+}
+
+// checkGrantedScopes verifies the token was granted all scopes that were requested.
+func checkGrantedScopes(token *hipchat.OAuthAccessToken, scopes []string) error {
+	granted := strings.Fields(token.Scope)
+	if len(granted) == 0 {
+		// Not all servers report the granted scopes
+		return nil
+	}
 
-		return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}, nil
+	var missing []string
+	for _, s := range scopes {
+		found := false
+		for _, g := range granted {
+			if s == g {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, s)
+		}
 	}
 
-	if DebugLogging {
-		fmt.Println("Not using a proxy.")
+	if len(missing) > 0 {
+		return fmt.Errorf("This command needs scope(s) %v, your add-on was granted %v", strings.Join(missing, ", "), strings.Join(granted, ", "))
+	}
+	return nil
+}
+
+// CheckResponse turns the error of a failed request into an *APIError,
+// explaining it further when the request was rejected because the
+// credentials are invalid or lack a required scope.
+// Errors without a response, like network errors, are returned as is.
+func CheckResponse(resp *http.Response, err error) error {
+	if err == nil || resp == nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		// The response could not be decoded
+		return err
+	}
+
+	apiErr := NewAPIError(resp)
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if authMode == AuthModeToken && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		apiErr.Hint = fmt.Sprintf("The configured token was rejected, make sure it is valid and has the scope(s): %v", strings.Join(requiredScopes, ", "))
+	}
+	return apiErr
+}
+
+// errorDoc is the error document HipChat sends along with failed requests.
+type errorDoc struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// errorDocument returns the error document of resp, if any.
+// The body of resp remains readable.
+func errorDocument(resp *http.Response) *errorDoc {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	return parseErrorDocument(body)
+}
+
+func parseErrorDocument(body []byte) *errorDoc {
+	var doc struct {
+		Error *errorDoc `json:"error"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	return doc.Error
+}
+
+// configDefaultHTTPClient returns the http client used to talk to HipChat,
+// which uses the configured proxy, timeouts and TLS settings and retries
+// failed requests.
+func configDefaultHTTPClient() (*http.Client, error) {
+	transport, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := newRetryTransport(transport)
+	if err != nil {
+		return nil, err
 	}
-	return http.DefaultClient, nil
+	return &http.Client{Transport: retry}, nil
 }
 
 func configureEndpoint(c *hipchat.Client) (*hipchat.Client, error) {
 	endpoint := viper.GetString("endpoint")
 	if endpoint != "" {
-		endpointURL, err := url.Parse(endpoint)
+		endpointURL, err := ParseEndpoint(endpoint)
 		if err != nil {
-			return nil, fmt.Errorf("Could not determine endpoint URL: %v", err)
+			return nil, err
 		}
 		c.BaseURL = endpointURL
 	}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTokenError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   int
+		// message is the message reported
+		message string
+	}{
+		{"invalid client", http.StatusUnauthorized, `{"error": "invalid_client", "error_description": "Invalid OAuth client credentials"}`, ExitAuth, "Invalid OAuth client credentials"},
+		{"invalid scope", http.StatusBadRequest, `{"error": "invalid_scope", "error_description": "Invalid scope: admin_room"}`, ExitPermission, "Invalid scope: admin_room"},
+		{"error document", http.StatusBadRequest, `{"error": {"code": 400, "message": "Invalid scope: admin_room", "type": "Bad Request"}}`, ExitAuth, "Invalid scope: admin_room"},
+		{"no document", http.StatusUnauthorized, "unauthorized", ExitAuth, ""},
+		{"server error", http.StatusServiceUnavailable, "", ExitServer, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Body: ioutil.NopCloser(strings.NewReader(tt.body))}
+			err := tokenError(resp, fmt.Errorf("Server returns status %d", tt.status), []string{"admin_room"})
+			if got := ExitCode(err); got != tt.code {
+				t.Errorf("exit code = %d, want %d (error: %v)", got, tt.code, err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("error = %q, want %q", err, tt.message)
+			}
+		})
+	}
+}
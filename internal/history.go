@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// History formats, selected with --format.
+const (
+	HistoryNDJSON = "ndjson"
+	HistoryCSV    = "csv"
+	HistoryText   = "text"
+	HistoryMbox   = "mbox"
+)
+
+// HistoryFormats are the formats a history can be written in.
+var HistoryFormats = []string{HistoryText, HistoryNDJSON, HistoryCSV, HistoryMbox}
+
+// HistoryMessage is a message in the history of a room. hipchat.Message
+// lacks the color, file and card of a message.
+type HistoryMessage struct {
+	ID            string         `json:"id"`
+	Date          string         `json:"date"`
+	Type          string         `json:"type"`
+	From          interface{}    `json:"from"`
+	Message       string         `json:"message"`
+	MessageFormat string         `json:"message_format,omitempty"`
+	Color         string         `json:"color,omitempty"`
+	Mentions      []hipchat.User `json:"mentions,omitempty"`
+	File          *HistoryFile   `json:"file,omitempty"`
+	// Card is the JSON of the card of the message.
+	Card string `json:"card,omitempty"`
+
+	// Raw is the message as returned by HipChat, with all its fields.
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes m and keeps data in m.Raw.
+func (m *HistoryMessage) UnmarshalJSON(data []byte) error {
+	type message HistoryMessage
+	if err := json.Unmarshal(data, (*message)(m)); err != nil {
+		return err
+	}
+	m.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// HistoryFile is a file shared in a room.
+type HistoryFile struct {
+	Name     string `json:"name"`
+	Size     int    `json:"size"`
+	URL      string `json:"url"`
+	ThumbURL string `json:"thumb_url,omitempty"`
+}
+
+// HistoryPage is a page of the history of a room.
+type HistoryPage struct {
+	Items      []HistoryMessage  `json:"items"`
+	StartIndex int               `json:"startIndex"`
+	MaxResults int               `json:"maxResults"`
+	Links      hipchat.PageLinks `json:"links"`
+}
+
+// Time returns when m was sent.
+func (m *HistoryMessage) Time() (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, m.Date)
+}
+
+// Sender returns the name of who sent m: the name of a user, or the label
+// of a notification.
+func (m *HistoryMessage) Sender() string {
+	switch from := m.From.(type) {
+	case string:
+		return from
+	case map[string]interface{}:
+		if name := cast.ToString(from["name"]); name != "" {
+			return name
+		}
+		return cast.ToString(from["mention_name"])
+	}
+	return ""
+}
+
+// mentionName returns the @mention name of the user who sent m, if a user sent it.
+func (m *HistoryMessage) mentionName() string {
+	if from, ok := m.From.(map[string]interface{}); ok {
+		return cast.ToString(from["mention_name"])
+	}
+	return ""
+}
+
+// Text returns the message of m as plain text, with a shared file as its URL.
+func (m *HistoryMessage) Text() string {
+	text := m.Message
+	if m.MessageFormat == "html" {
+		text = StripHTML(text)
+	}
+	if m.File != nil {
+		text += "\n" + m.File.URL
+	}
+	return strings.TrimSpace(text)
+}
+
+// ParseHistoryTime parses a --since or --until value: a date and time like
+// 2017-01-02T15:04:05Z or 2017-01-02 15:04, a date, a unix time, or a
+// duration like 24h before now. Times without a zone are in loc.
+func ParseHistoryTime(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if unix, err := cast.ToInt64E(value); err == nil && unix > 0 {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%q should be a date like 2017-01-02, a time like 2017-01-02 15:04 or a duration like 24h", value)
+}
+
+// HistoryWriter writes messages in a history format.
+type HistoryWriter interface {
+	Write(m *HistoryMessage) error
+	// Flush writes any buffered data.
+	Flush() error
+}
+
+// NewHistoryWriter returns a writer of the history of room to w in format,
+// which shows times in loc.
+func NewHistoryWriter(w io.Writer, format, room string, loc *time.Location) (HistoryWriter, error) {
+	switch format {
+	case HistoryNDJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return ndjsonWriter{enc}, nil
+	case HistoryCSV:
+		return &csvWriter{w: csv.NewWriter(w), loc: loc}, nil
+	case HistoryText:
+		return &textWriter{w: w, loc: loc}, nil
+	case HistoryMbox:
+		return &mboxWriter{w: w, room: room, loc: loc}, nil
+	}
+	return nil, fmt.Errorf("unknown history format %q, should be %v", format, strings.Join(HistoryFormats, ", "))
+}
+
+// localTime returns the time m was sent in loc, or its date as is if it cannot be parsed.
+func localTime(m *HistoryMessage, loc *time.Location, layout string) string {
+	t, err := m.Time()
+	if err != nil {
+		return m.Date
+	}
+	return t.In(loc).Format(layout)
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w ndjsonWriter) Write(m *HistoryMessage) error {
+	return w.enc.Encode(m)
+}
+
+func (w ndjsonWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	loc    *time.Location
+	header bool
+}
+
+func (w *csvWriter) Write(m *HistoryMessage) error {
+	if !w.header {
+		w.header = true
+		if err := w.w.Write([]string{"date", "id", "type", "from", "message_format", "message", "file"}); err != nil {
+			return err
+		}
+	}
+	file := ""
+	if m.File != nil {
+		file = m.File.URL
+	}
+	return w.w.Write([]string{localTime(m, w.loc, time.RFC3339), m.ID, m.Type, m.Sender(), m.MessageFormat, m.Message, file})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// textWriter writes a transcript, a line per message with the lines after
+// the first indented.
+type textWriter struct {
+	w   io.Writer
+	loc *time.Location
+}
+
+func (w *textWriter) Write(m *HistoryMessage) error {
+	text := strings.Replace(m.Text(), "\n", "\n    ", -1)
+	_, err := fmt.Fprintf(w.w, "[%v] %v: %v\n", localTime(m, w.loc, "2006-01-02 15:04:05"), m.Sender(), text)
+	return err
+}
+
+func (w *textWriter) Flush() error {
+	return nil
+}
+
+// mboxWriter writes every message as a mail in mbox format, so the history
+// can be read with a mail client.
+type mboxWriter struct {
+	w    io.Writer
+	room string
+	loc  *time.Location
+}
+
+func (w *mboxWriter) Write(m *HistoryMessage) error {
+	t, err := m.Time()
+	if err != nil {
+		t = time.Time{}
+	}
+	t = t.In(w.loc)
+
+	from := fmt.Sprintf("%q <%v@hipchat>", m.Sender(), m.mentionName())
+	if m.mentionName() == "" {
+		from = fmt.Sprintf("%q <notification@hipchat>", m.Sender())
+	}
+	contentType := "text/plain"
+	if m.MessageFormat == "html" {
+		contentType = "text/html"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From hipchat-cli %v\n", t.Format(time.ANSIC))
+	fmt.Fprintf(&b, "From: %v\n", from)
+	fmt.Fprintf(&b, "Date: %v\n", t.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Subject: %v\n", w.room)
+	fmt.Fprintf(&b, "Message-ID: <%v@hipchat>\n", m.ID)
+	fmt.Fprintf(&b, "Content-Type: %v; charset=utf-8\n\n", contentType)
+	body := m.Message
+	if m.File != nil {
+		body = strings.TrimSpace(body + "\n" + m.File.URL)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		// Lines starting with From would start a new mail
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = ">" + line
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+	_, err = io.WriteString(w.w, b.String())
+	return err
+}
+
+func (w *mboxWriter) Flush() error {
+	return nil
+}
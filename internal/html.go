@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedTags are the HTML tags HipChat renders in messages, with the
+// attributes that are kept by SanitizeHTML.
+var allowedTags = map[string][]string{
+	"a":      {"href"},
+	"b":      nil,
+	"strong": nil,
+	"i":      nil,
+	"em":     nil,
+	"u":      nil,
+	"s":      nil,
+	"strike": nil,
+	"code":   nil,
+	"pre":    nil,
+	"br":     nil,
+	"p":      nil,
+	"ul":     nil,
+	"ol":     nil,
+	"li":     nil,
+	"table":  nil,
+	"tr":     nil,
+	"th":     nil,
+	"td":     nil,
+	"img":    {"src", "alt", "width", "height"},
+}
+
+// droppedTags are removed by SanitizeHTML along with their content.
+var droppedTags = map[string]bool{"script": true, "style": true}
+
+var (
+	tagPattern       = regexp.MustCompile(`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:[^<>"']|"[^"]*"|'[^']*')*)>`)
+	attributePattern = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// EscapeHTML escapes text so it is shown as is in an HTML message, keeping
+// its line breaks.
+func EscapeHTML(text string) string {
+	return strings.Replace(html.EscapeString(text), "\n", "<br>", -1)
+}
+
+// SanitizeHTML removes the tags and attributes HipChat does not render, and
+// scripts or links other than http, https and mailto, from an HTML message
+// that includes untrusted text. Other text is escaped.
+func SanitizeHTML(message string) string {
+	var b strings.Builder
+	dropping := ""
+	last := 0
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(message, -1) {
+		if dropping == "" {
+			b.WriteString(html.EscapeString(html.UnescapeString(message[last:m[0]])))
+		}
+		last = m[1]
+		if m[4] < 0 {
+			// A comment
+			continue
+		}
+
+		closing := m[3] > m[2]
+		name := strings.ToLower(message[m[4]:m[5]])
+		switch {
+		case dropping != "":
+			if closing && name == dropping {
+				dropping = ""
+			}
+		case droppedTags[name]:
+			if !closing {
+				dropping = name
+			}
+		default:
+			attributes, ok := allowedTags[name]
+			if !ok {
+				continue
+			}
+			if closing {
+				b.WriteString("</" + name + ">")
+				continue
+			}
+			b.WriteString("<" + name)
+			for _, a := range attributePattern.FindAllStringSubmatch(message[m[6]:m[7]], -1) {
+				attr := strings.ToLower(a[1])
+				value := html.UnescapeString(strings.Trim(a[2], `"'`))
+				if !stringInSlice(attr, attributes) || ((attr == "href" || attr == "src") && !safeURL(value)) {
+					continue
+				}
+				b.WriteString(" " + attr + `="` + html.EscapeString(value) + `"`)
+			}
+			b.WriteString(">")
+		}
+	}
+	if dropping == "" {
+		b.WriteString(html.EscapeString(html.UnescapeString(message[last:])))
+	}
+	return b.String()
+}
+
+// StripHTML returns the text of an HTML message, with line breaks for the
+// tags that start a new line.
+func StripHTML(message string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(message, -1) {
+		b.WriteString(html.UnescapeString(message[last:m[0]]))
+		last = m[1]
+		if m[4] < 0 {
+			continue
+		}
+		closing := m[3] > m[2]
+		switch strings.ToLower(message[m[4]:m[5]]) {
+		case "br":
+			b.WriteString("\n")
+		case "p", "li", "tr":
+			if !closing {
+				b.WriteString("\n")
+			}
+		}
+	}
+	b.WriteString(html.UnescapeString(message[last:]))
+	return strings.TrimSpace(b.String())
+}
+
+// safeURL reports if a link to u can be followed safely.
+func safeURL(u string) bool {
+	u = strings.ToLower(strings.TrimSpace(u))
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(u, scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package internal
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"text", "disk 95% full", "disk 95% full"},
+		{"escapes text", "a < b & c", "a &lt; b &amp; c"},
+		{"keeps entities", "a &lt; b &amp; c", "a &lt; b &amp; c"},
+		{"allowed tags", "<b>down</b> <I>now</I><br/>", "<b>down</b> <i>now</i><br>"},
+		{"unknown tags", "<div><span>x</span></div>", "x"},
+		{"scripts", "a<script>alert(1)</script>b<style>p{}</style>c", "abc"},
+		{"comments", "a<!-- <b>x</b> -->b", "ab"},
+		{"attributes", `<b class="x" onclick="y">z</b>`, "<b>z</b>"},
+		{"links", `<a href="https://example.com/?a=1&amp;b=2" title="t">x</a>`, `<a href="https://example.com/?a=1&amp;b=2">x</a>`},
+		{"unsafe links", `<a href="javascript:alert(1)">x</a><img src='data:x' alt="y">`, `<a>x</a><img alt="y">`},
+		{"quoted brackets", `<a href="http://x/?q=>">y</a>`, `<a href="http://x/?q=&gt;">y</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeHTML(tt.message); got != tt.want {
+				t.Errorf("SanitizeHTML(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogLevel determines which messages are logged.
+type LogLevel int
+
+// Log levels, from least to most verbose.
+const (
+	LevelError LogLevel = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = map[LogLevel]string{
+	LevelError: "error",
+	LevelWarn:  "warning",
+	LevelInfo:  "info",
+	LevelDebug: "debug",
+}
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var (
+	logLevel  = LevelWarn
+	logFormat = LogFormatText
+	// logOutput receives all log messages, stdout is reserved for the results of commands.
+	logOutput io.Writer = os.Stderr
+)
+
+// ConfigureLogging sets the log level and format.
+// debug takes precedence over verbose, which takes precedence over quiet.
+func ConfigureLogging(quiet, verbose, debug bool, format string) error {
+	switch {
+	case debug:
+		logLevel = LevelDebug
+	case verbose:
+		logLevel = LevelInfo
+	case quiet:
+		logLevel = LevelError
+	default:
+		logLevel = LevelWarn
+	}
+
+	switch format {
+	case "", LogFormatText:
+		logFormat = LogFormatText
+	case LogFormatJSON:
+		logFormat = LogFormatJSON
+	default:
+		return fmt.Errorf("invalid log format %q, should be text or json", format)
+	}
+	return nil
+}
+
+// DebugEnabled reports if debug messages are logged.
+func DebugEnabled() bool {
+	return logLevel >= LevelDebug
+}
+
+// Debugf logs a message useful for troubleshooting.
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, format, args...)
+}
+
+// Infof logs the progress of a command.
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, format, args...)
+}
+
+// Warnf logs a problem that does not stop the command.
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, format, args...)
+}
+
+// LogError logs the error that stopped a command. It is shown even with --quiet.
+func LogError(err error) {
+	logf(LevelError, "%v", err)
+}
+
+// DebugDump logs the output of httputil.DumpResponse and friends.
+func DebugDump(data []byte, err error) {
+	if err != nil {
+		Debugf("%v", err)
+	} else {
+		Debugf("%s", data)
+	}
+}
+
+func logf(level LogLevel, format string, args ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	if logFormat == LogFormatJSON {
+		enc := json.NewEncoder(logOutput)
+		enc.SetEscapeHTML(false)
+		enc.Encode(struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"msg"`
+		}{time.Now().Format(time.RFC3339), levelNames[level], msg})
+		return
+	}
+
+	switch level {
+	case LevelError:
+		fmt.Fprintf(logOutput, "Error: %v\n", msg)
+	case LevelWarn:
+		fmt.Fprintf(logOutput, "Warning: %v\n", msg)
+	default:
+		fmt.Fprintln(logOutput, msg)
+	}
+}
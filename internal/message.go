@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// MaxMessageLength is the maximum number of characters of a message accepted by HipChat.
+const MaxMessageLength = 10000
+
+// Notification is the body of POST /room/{room}/notification, which
+// hipchat.NotificationRequest only partly supports.
+type Notification struct {
+	hipchat.NotificationRequest
+	// AttachTo is the ID of the message the notification is attached to.
+	AttachTo string `json:"attach_to,omitempty"`
+}
+
+// ReadMessage reads a message from a file, or from stdin if file is "-".
+func ReadMessage(file string) (string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", fmt.Errorf("Error while reading message: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Error while reading message: %v", err)
+	}
+	return string(data), nil
+}
+
+// TailLines returns the last n lines of message.
+func TailLines(message string, n int) string {
+	lines := strings.SplitAfter(strings.TrimRight(message, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "")
+}
+
+// SplitMessage splits message in parts of at most max characters, at the
+// end of a line where possible.
+func SplitMessage(message string, max int) []string {
+	return splitMessage(message, max, func(message string, end int) int {
+		if nl := strings.LastIndex(message[:end], "\n"); nl > 0 {
+			return nl + 1
+		}
+		return end
+	})
+}
+
+// SplitHTMLMessage splits an HTML message in parts of at most max characters
+// like SplitMessage, but never inside a tag or an entity. It splits at the end
+// of a line, or else between tags, where possible.
+func SplitHTMLMessage(message string, max int) []string {
+	return splitMessage(message, max, htmlCut)
+}
+
+// splitMessage splits message in parts of at most max characters. cut returns
+// the byte offset to split message at, given the offset end of the first
+// character that does not fit.
+func splitMessage(message string, max int, cut func(message string, end int) int) []string {
+	var parts []string
+	for utf8.RuneCountInString(message) > max {
+		// Byte offset of the first character that does not fit
+		end := 0
+		for i := 0; i < max; i++ {
+			_, size := utf8.DecodeRuneInString(message[end:])
+			end += size
+		}
+
+		at := cut(message, end)
+		if part := strings.TrimRight(message[:at], "\n"); part != "" {
+			parts = append(parts, part)
+		}
+		message = message[at:]
+	}
+	return append(parts, message)
+}
+
+// htmlCut returns the offset before end to split an HTML message at: the
+// last end of a line, else the last tag boundary, else the last offset that
+// is not inside a tag or an entity. It returns end if there is none, when a
+// single tag is longer than a part.
+func htmlCut(message string, end int) int {
+	line, tag, safe := 0, 0, 0
+	inTag, inEntity := false, false
+	for i := 0; i < end; i++ {
+		c := message[i]
+		switch {
+		case inTag:
+			inTag = c != '>'
+		case c == '<':
+			inTag, inEntity = true, false
+		case c == '&':
+			inEntity = true
+		case inEntity:
+			inEntity = c == '#' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+		}
+
+		at := i + 1
+		if inTag || inEntity || (at < len(message) && !utf8.RuneStart(message[at])) {
+			continue
+		}
+		safe = at
+		if c == '\n' {
+			line = at
+		}
+		if c == '>' || (at < len(message) && message[at] == '<') {
+			tag = at
+		}
+	}
+
+	switch {
+	case line > 0:
+		return line
+	case tag > 0:
+		return tag
+	case safe > 0:
+		return safe
+	}
+	return end
+}
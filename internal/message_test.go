@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		max     int
+		want    []string
+	}{
+		{"fits", "hello", 5, []string{"hello"}},
+		{"empty", "", 5, []string{""}},
+		{"no lines", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"at line ends", "one\ntwo\nthree", 9, []string{"one\ntwo", "three"}},
+		{"long line", "ab\ncdefghij", 5, []string{"ab", "cdefg", "hij"}},
+		{"multibyte", "ééééé", 2, []string{"éé", "éé", "é"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitMessage(tt.message, tt.max); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMessage(%q, %d) = %q, want %q", tt.message, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitHTMLMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		max     int
+		want    []string
+	}{
+		{"fits", "<b>hi</b>", 9, []string{"<b>hi</b>"}},
+		{"between tags", "<b>bold</b><i>it</i>", 7, []string{"<b>bold", "</b><i>", "it</i>"}},
+		{"not in a tag", `text <a href="x">y</a>`, 12, []string{"text ", `<a href="x">`, "y</a>"}},
+		{"not in an entity", "ab &amp; cd &#39; e", 7, []string{"ab ", "&amp; c", "d &#39;", " e"}},
+		{"at line ends", "<p>one</p>\n<p>two</p>", 14, []string{"<p>one</p>", "<p>two</p>"}},
+		{"text only", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"multibyte", "<i>é</i>éé", 5, []string{"<i>é", "</i>", "éé"}},
+		{"multibyte text", "ééé", 2, []string{"éé", "é"}},
+		{"tag longer than a part", `<a href="xyz">`, 5, []string{"<a hr", `ef="x`, `yz">`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitHTMLMessage(tt.message, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitHTMLMessage(%q, %d) = %q, want %q", tt.message, tt.max, got, tt.want)
+			}
+			for _, part := range got {
+				if n := utf8.RuneCountInString(part); n > tt.max {
+					t.Errorf("part %q has %d characters, more than %d", part, n, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitHTMLMessageKeepsMessage(t *testing.T) {
+	message := strings.Repeat(`<a href="https://example.com/?a=1&amp;b=2">link &lt;1&gt;</a> &#x263A; `, 200)
+	parts := SplitHTMLMessage(message, 1000)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want more than 1", len(parts))
+	}
+	if got := strings.Join(parts, ""); got != message {
+		t.Errorf("the parts do not add up to the message")
+	}
+	for i, part := range parts {
+		if strings.Count(part, "<") != strings.Count(part, ">") {
+			t.Errorf("part %d splits a tag: %q", i, part)
+		}
+		if amp, semi := strings.LastIndex(part, "&"), strings.LastIndex(part, ";"); amp > semi {
+			t.Errorf("part %d splits an entity: %q", i, part[amp:])
+		}
+	}
+}
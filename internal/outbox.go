@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Defaults of the spool_max_age and spool_max_attempts config keys.
+const (
+	defaultSpoolMaxAge      = time.Hour
+	defaultSpoolMaxAttempts = 10
+)
+
+// outboxLockAge is the age after which the lock of a flush is considered stale.
+const outboxLockAge = 10 * time.Minute
+
+// OutboxEntry is a notification that could not be sent and is kept in the
+// outbox until it is sent by outbox flush.
+type OutboxEntry struct {
+	ID           string        `json:"id"`
+	Profile      string        `json:"profile,omitempty"`
+	Room         string        `json:"room"`
+	Notification *Notification `json:"notification"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Attempts     int           `json:"attempts"`
+	LastAttempt  time.Time     `json:"last_attempt"`
+	LastError    string        `json:"last_error"`
+
+	file string
+}
+
+// Expired reports if the entry is too old or failed too often to be sent.
+func (e OutboxEntry) Expired() bool {
+	maxAge, err := durationSetting("spool_max_age", defaultSpoolMaxAge)
+	if err != nil {
+		Warnf("%v", err)
+		maxAge = defaultSpoolMaxAge
+	}
+	maxAttempts := defaultSpoolMaxAttempts
+	if viper.IsSet("spool_max_attempts") {
+		maxAttempts = cast.ToInt(viper.Get("spool_max_attempts"))
+	}
+	return time.Since(e.CreatedAt) > maxAge || e.Attempts >= maxAttempts
+}
+
+// OutboxDir returns the directory holding the outbox, creating it if needed.
+func OutboxDir() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "outbox")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// SpoolNotification stores a notification for room that failed with cause in the outbox.
+func SpoolNotification(room string, n *Notification, cause error) (*OutboxEntry, error) {
+	dir, err := OutboxDir()
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e := &OutboxEntry{
+		ID:           id.String(),
+		Profile:      ActiveProfile(),
+		Room:         room,
+		Notification: n,
+		CreatedAt:    now,
+		Attempts:     1,
+		LastAttempt:  now,
+		LastError:    cause.Error(),
+	}
+	// The name sorts the entries in the order they were created
+	e.file = filepath.Join(dir, now.UTC().Format("20060102T150405.000000000")+"-"+e.ID+".json")
+	return e, e.Save()
+}
+
+// Save writes the entry to the outbox.
+func (e *OutboxEntry) Save() error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(e.file, data, 0600)
+}
+
+// Remove deletes the entry from the outbox.
+func (e *OutboxEntry) Remove() error {
+	err := os.Remove(e.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// validate checks an entry read from the outbox, which may have been
+// truncated or edited by hand.
+func (e *OutboxEntry) validate() error {
+	if _, err := uuid.ParseHex(e.ID); err != nil {
+		return fmt.Errorf("invalid id %q", e.ID)
+	}
+	if e.Room == "" || e.Notification == nil {
+		return fmt.Errorf("the room or notification is missing")
+	}
+	return nil
+}
+
+// ReadOutbox returns the entries in the outbox, oldest first.
+func ReadOutbox() ([]*OutboxEntry, error) {
+	dir, err := OutboxDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*OutboxEntry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			// Sent by a concurrent flush
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		e := &OutboxEntry{}
+		if err := json.Unmarshal(data, e); err != nil {
+			Warnf("Ignoring invalid outbox entry %v: %v", file, err)
+			continue
+		}
+		if err := e.validate(); err != nil {
+			Warnf("Ignoring invalid outbox entry %v: %v", file, err)
+			continue
+		}
+		e.file = file
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	return entries, nil
+}
+
+// LockOutbox prevents concurrent flushes from sending the same notification
+// twice, and purges from removing a notification that is being sent. The
+// returned function releases the lock.
+func LockOutbox() (func(), error) {
+	dir, err := OutboxDir()
+	if err != nil {
+		return nil, err
+	}
+	lock := filepath.Join(dir, ".lock")
+
+	unlock, err := lockFile(lock, 0, outboxLockAge)
+	if err == errLocked {
+		return nil, fmt.Errorf("the outbox is being flushed or purged by another process, remove %v if it is not", lock)
+	}
+	return unlock, err
+}
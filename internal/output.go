@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats, selected with --output.
+const (
+	OutputText     = "text"
+	OutputJSON     = "json"
+	OutputYAML     = "yaml"
+	OutputTable    = "table"
+	OutputTemplate = "template"
+)
+
+// Result is the data returned by a command, which is rendered in the output
+// format chosen by the user.
+type Result struct {
+	// Value is rendered by the json, yaml and template formats.
+	Value interface{}
+	// Header and Rows are rendered by the table format.
+	Header []string
+	Rows   [][]string
+	// Text renders the default text output. When nil the table is shown.
+	Text func(w io.Writer) error
+}
+
+// templateFuncs are available in --output template=...
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ParseOutputFormat splits an --output value like json or template={{.Name}}
+// into the format and the template.
+func ParseOutputFormat(output string) (format, tmpl string, err error) {
+	format = output
+	if i := strings.Index(output, "="); i >= 0 {
+		format, tmpl = output[:i], output[i+1:]
+	}
+
+	switch format {
+	case "":
+		return OutputText, "", nil
+	case OutputText, OutputJSON, OutputYAML, OutputTable:
+		if tmpl != "" {
+			return "", "", fmt.Errorf("output format %v does not take a value", format)
+		}
+		return format, "", nil
+	case OutputTemplate:
+		if tmpl == "" {
+			return "", "", fmt.Errorf("specify the template, like --output 'template={{.Name}}'")
+		}
+		return format, tmpl, nil
+	default:
+		return "", "", fmt.Errorf("unknown output format %q, should be text, json, yaml, table or template=<template>", output)
+	}
+}
+
+// PrintResult writes r to stdout in the format selected by the output setting.
+func PrintResult(r Result) error {
+	return WriteResult(os.Stdout, viper.GetString("output"), r)
+}
+
+// WriteResult writes r to w in the given output format.
+func WriteResult(w io.Writer, output string, r Result) error {
+	format, tmpl, err := ParseOutputFormat(output)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r.Value)
+	case OutputYAML:
+		return writeYAML(w, r.Value)
+	case OutputTemplate:
+		return writeTemplate(w, tmpl, r.Value)
+	case OutputText:
+		if r.Text != nil {
+			return r.Text(w)
+		}
+	}
+	return writeTable(w, r.Header, r.Rows)
+}
+
+// writeYAML renders value as YAML using the names of its JSON encoding,
+// so both formats have the same keys.
+func writeYAML(w io.Writer, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	data, err = yaml.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeTemplate executes tmpl for value, or for every element if value is
+// a list. Every execution is followed by a newline.
+func writeTemplate(w io.Writer, tmpl string, value interface{}) error {
+	t, err := template.New("output").Funcs(templateFuncs).Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("Could not parse output template: %v", err)
+	}
+
+	items := []interface{}{value}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Slice {
+		items = make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).Interface()
+		}
+	}
+
+	for _, item := range items {
+		if err := t.Execute(w, item); err != nil {
+			return fmt.Errorf("Could not render output template: %v", err)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTable renders an aligned table.
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if len(header) > 0 {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
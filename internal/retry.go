@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Defaults of the retry_max_attempts and retry_deadline config keys.
+const (
+	defaultRetryMaxAttempts = 4
+	defaultRetryDeadline    = 2 * time.Minute
+)
+
+// Delays between attempts, which double after every attempt.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retryTransport retries requests that failed because of a transient error
+// or a rate limit. Requests are only retried if that cannot cause them to be
+// handled twice: idempotent requests are retried after any transient error,
+// others only when HipChat rejected them because of its rate limit or the
+// connection could not be established.
+type retryTransport struct {
+	next        http.RoundTripper
+	maxAttempts int
+	deadline    time.Duration
+	// timeout limits every attempt, including reading the response body.
+	timeout time.Duration
+
+	mu sync.Mutex
+	// notBefore is the time the rate limit is reset, when it was exhausted.
+	notBefore time.Time
+}
+
+// newRetryTransport wraps next with the retry and timeout settings from the config.
+func newRetryTransport(next http.RoundTripper) (*retryTransport, error) {
+	timeout, err := durationSetting("timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	t := &retryTransport{next: next, maxAttempts: defaultRetryMaxAttempts, deadline: defaultRetryDeadline, timeout: timeout}
+
+	if viper.IsSet("retry_max_attempts") {
+		n, err := cast.ToIntE(viper.Get("retry_max_attempts"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("retry_max_attempts should be a number of at least 1")
+		}
+		t.maxAttempts = n
+	}
+	if viper.IsSet("retry_deadline") {
+		d, err := ParseDuration(viper.Get("retry_deadline"))
+		if err != nil {
+			return nil, fmt.Errorf("retry_deadline: %v", err)
+		}
+		t.deadline = d
+	}
+	return t, nil
+}
+
+// ParseDuration parses a duration setting like 30s or 2m.
+func ParseDuration(v interface{}) (time.Duration, error) {
+	d, err := cast.ToDurationE(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q should be a duration like 30s or 2m", fmt.Sprint(v))
+	}
+	return d, nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	retryable := isIdempotent(req)
+	// A request whose body cannot be sent again is never retried
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 1; ; attempt++ {
+		if err := t.waitForRateLimit(req); err != nil {
+			return nil, err
+		}
+
+		r := req
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := t.roundTrip(r)
+		if resp != nil {
+			t.trackRateLimit(resp)
+		}
+
+		reason, delay := t.shouldRetry(resp, err, retryable)
+		if reason == "" || attempt >= t.maxAttempts {
+			return resp, err
+		}
+		if !rewindable {
+			Debugf("Not retrying %v %v, the body of the request cannot be sent again", req.Method, req.URL)
+			return resp, err
+		}
+		if delay == 0 {
+			delay = backoff(attempt)
+		}
+		if time.Since(start)+delay > t.deadline {
+			Debugf("Not retrying %v %v, retry_deadline of %v would be exceeded", req.Method, req.URL, t.deadline)
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		Infof("%v %v: %v, retrying in %v (attempt %d of %d)", req.Method, req.URL, reason, delay.Truncate(time.Millisecond), attempt+1, t.maxAttempts)
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// roundTrip sends a single attempt, which is canceled after the timeout.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("no response within timeout of %v: %w", t.timeout, err)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// shouldRetry returns why the request should be retried, if it should, and
+// the delay requested by HipChat, if any.
+func (t *retryTransport) shouldRetry(resp *http.Response, err error, retryable bool) (string, time.Duration) {
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			// The request was never sent
+			return err.Error(), 0
+		}
+		if retryable {
+			return err.Error(), 0
+		}
+		return "", 0
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// The request was rejected without being handled
+		return "rate limited", rateLimitDelay(resp)
+	case retryable && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout):
+		// Other server errors are likely to happen again
+		return resp.Status, retryAfter(resp)
+	}
+	return "", 0
+}
+
+// trackRateLimit remembers when the rate limit is reset if it is exhausted,
+// so the next request waits instead of being rejected.
+func (t *retryTransport) trackRateLimit(resp *http.Response) {
+	if resp.Header.Get("X-Ratelimit-Remaining") != "0" {
+		return
+	}
+	if reset := rateLimitReset(resp); !reset.IsZero() {
+		t.mu.Lock()
+		t.notBefore = reset
+		t.mu.Unlock()
+	}
+}
+
+// waitForRateLimit waits until the rate limit is reset, if it was exhausted.
+func (t *retryTransport) waitForRateLimit(req *http.Request) error {
+	t.mu.Lock()
+	wait := time.Until(t.notBefore)
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if wait > t.deadline {
+		// Let HipChat reject the request rather than waiting for a long time
+		return nil
+	}
+
+	Infof("Rate limit of HipChat exhausted, waiting %v", wait.Truncate(time.Millisecond))
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// isIdempotent reports if sending req twice has the same effect as sending it once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	// Requesting an access token has no side effects
+	return strings.HasSuffix(req.URL.Path, "/oauth/token")
+}
+
+// backoff returns the delay before the next attempt: exponential with full jitter.
+func backoff(attempt int) time.Duration {
+	max := retryBaseDelay << uint(attempt-1)
+	if max > retryMaxDelay || max <= 0 {
+		max = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(max))) + time.Millisecond
+}
+
+// rateLimitDelay returns how long to wait before a rate limited request may be sent again.
+func rateLimitDelay(resp *http.Response) time.Duration {
+	if d := retryAfter(resp); d > 0 {
+		return d
+	}
+	if reset := rateLimitReset(resp); !reset.IsZero() {
+		return time.Until(reset)
+	}
+	return 0
+}
+
+// retryAfter parses the Retry-After header, in seconds or as HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// rateLimitReset parses the X-Ratelimit-Reset header, the unix time at which
+// the rate limit of HipChat is reset.
+func rateLimitReset(resp *http.Response) time.Time {
+	s, err := strconv.ParseInt(resp.Header.Get("X-Ratelimit-Reset"), 10, 64)
+	if err != nil || s <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(s, 0)
+}
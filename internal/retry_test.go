@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		// rewind makes the body of the request readable again
+		rewind bool
+		// want is the number of requests sent
+		want int
+	}{
+		{"get unavailable", http.MethodGet, []int{503, 200}, true, 2},
+		{"get server error", http.MethodGet, []int{500, 200}, true, 1},
+		{"post unavailable", http.MethodPost, []int{503, 200}, true, 1},
+		{"post rate limited", http.MethodPost, []int{429, 200}, true, 2},
+		{"post rate limited without rewind", http.MethodPost, []int{429, 200}, false, 1},
+		{"put unavailable without rewind", http.MethodPut, []int{503, 200}, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if r.Method != http.MethodGet && string(body) != "{}" {
+					t.Errorf("request %d has body %q, want {}", requests+1, body)
+				}
+				w.WriteHeader(tt.statuses[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			transport := &retryTransport{next: http.DefaultTransport, maxAttempts: len(tt.statuses), deadline: time.Minute, timeout: time.Minute}
+			req, err := http.NewRequest(tt.method, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.method != http.MethodGet {
+				req.Body = ioutil.NopCloser(strings.NewReader("{}"))
+				if tt.rewind {
+					req.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(strings.NewReader("{}")), nil }
+				}
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if requests != tt.want {
+				t.Errorf("sent %d requests, want %d", requests, tt.want)
+			}
+		})
+	}
+}
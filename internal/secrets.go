@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrSecretNotFound is returned by a SecretStore that does not hold the requested secret.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore keeps secrets outside of the config file.
+type SecretStore interface {
+	// Get returns the secret stored under name, or ErrSecretNotFound.
+	Get(name string) (string, error)
+	// Set stores secret under name.
+	Set(name, secret string) error
+	// Delete removes the secret stored under name.
+	Delete(name string) error
+}
+
+// Secret store backends, selected with the secret_backend config key.
+const (
+	SecretBackendAuto          = "auto"
+	SecretBackendSecretService = "secret-service"
+	SecretBackendFile          = "file"
+)
+
+// DetectSecretBackend returns the backend used for auto: the Secret Service
+// when secret-tool is installed, and an encrypted file otherwise.
+func DetectSecretBackend() string {
+	if _, err := exec.LookPath("secret-tool"); err == nil {
+		return SecretBackendSecretService
+	}
+	return SecretBackendFile
+}
+
+// NewSecretStore returns the store for backend.
+func NewSecretStore(backend string) (SecretStore, error) {
+	if backend == "" || backend == SecretBackendAuto {
+		backend = DetectSecretBackend()
+	}
+
+	switch backend {
+	case SecretBackendSecretService:
+		return secretServiceStore{}, nil
+	case SecretBackendFile:
+		return newFileSecretStore()
+	default:
+		return nil, fmt.Errorf("unknown secret_backend %q, should be auto, secret-service or file", backend)
+	}
+}
+
+// SecretName returns the name under which the secret for key is stored,
+// which is specific to the active profile.
+func SecretName(key string) string {
+	profile := ActiveProfile()
+	if profile == "" {
+		profile = "default"
+	}
+	return profile + "/" + key
+}
+
+// ResolveSecret returns the secret for key. It is read from the first of:
+//   - the key itself
+//   - the file named by <key>_file
+//   - the output of the command in <key>_command
+//   - the secret store selected by secret_backend, if configured
+//
+// An empty string is returned if the secret is not configured at all.
+func ResolveSecret(key string) (string, error) {
+	if secret := viper.GetString(key); secret != "" {
+		return secret, nil
+	}
+
+	if file := viper.GetString(key + "_file"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("Could not read %v_file: %v", key, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if command := viper.GetString(key + "_command"); command != "" {
+		var stderr bytes.Buffer
+		c := exec.Command("sh", "-c", command)
+		c.Stderr = &stderr
+		out, err := c.Output()
+		if err != nil {
+			return "", fmt.Errorf("%v_command failed: %v %v", key, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+
+	backend := viper.GetString("secret_backend")
+	if backend == "" {
+		return "", nil
+	}
+	store, err := NewSecretStore(backend)
+	if err != nil {
+		return "", err
+	}
+	secret, err := store.Get(SecretName(key))
+	if err == ErrSecretNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Could not read %v from the %v secret store: %v", key, backend, err)
+	}
+	return secret, nil
+}
+
+// secretServiceStore keeps secrets in the Secret Service (GNOME Keyring, KWallet)
+// using the secret-tool command.
+type secretServiceStore struct{}
+
+func (secretServiceStore) attributes(name string) []string {
+	return []string{"service", "hipchat-cli", "name", name}
+}
+
+func (s secretServiceStore) Get(name string) (string, error) {
+	out, err := exec.Command("secret-tool", append([]string{"lookup"}, s.attributes(name)...)...).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok && len(out) == 0 {
+			return "", ErrSecretNotFound
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (s secretServiceStore) Set(name, secret string) error {
+	c := exec.Command("secret-tool", append([]string{"store", "--label=hipchat-cli " + name}, s.attributes(name)...)...)
+	c.Stdin = strings.NewReader(secret)
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (s secretServiceStore) Delete(name string) error {
+	return exec.Command("secret-tool", append([]string{"clear"}, s.attributes(name)...)...).Run()
+}
+
+// fileSecretStore keeps secrets in a file encrypted with AES-GCM.
+// The key is derived from the HIPCHAT_SECRETS_PASSPHRASE environment variable
+// or, if that is not set, from a random key file, which is stored next to the
+// secrets unless secrets_key_file is set.
+type fileSecretStore struct {
+	file    string
+	keyFile string
+}
+
+// encryptedSecrets is the on disk format of the fileSecretStore.
+type encryptedSecrets struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func newFileSecretStore() (SecretStore, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	keyFile := viper.GetString("secrets_key_file")
+	if keyFile == "" {
+		keyFile = filepath.Join(dir, "secrets.key")
+	}
+	return fileSecretStore{
+		file:    filepath.Join(dir, "secrets.enc"),
+		keyFile: keyFile,
+	}, nil
+}
+
+func (s fileSecretStore) Get(name string) (string, error) {
+	secrets, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s fileSecretStore) Set(name, secret string) error {
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	secrets[name] = secret
+	return s.write(secrets)
+}
+
+func (s fileSecretStore) Delete(name string) error {
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(secrets, name)
+	return s.write(secrets)
+}
+
+// keyNextToSecrets reports if the secrets are encrypted with a key file in
+// the same directory, which protects them only from being read by accident.
+func (s fileSecretStore) keyNextToSecrets() bool {
+	return os.Getenv(EnvPrefix+"_SECRETS_PASSPHRASE") == "" && filepath.Dir(s.keyFile) == filepath.Dir(s.file)
+}
+
+// passphrase returns the passphrase for the encryption key, creating a random
+// key file if no passphrase is available and create is set.
+func (s fileSecretStore) passphrase(create bool) (string, error) {
+	if p := os.Getenv(EnvPrefix + "_SECRETS_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+
+	data, err := ioutil.ReadFile(s.keyFile)
+	if os.IsNotExist(err) && create {
+		data = make([]byte, 32)
+		if _, err := rand.Read(data); err != nil {
+			return "", err
+		}
+		return string(data), writeFileAtomic(s.keyFile, data, 0600)
+	}
+	return string(data), err
+}
+
+func (s fileSecretStore) read() (map[string]string, error) {
+	secrets := map[string]string{}
+
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var enc encryptedSecrets
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, fmt.Errorf("Could not parse %v: %v", s.file, err)
+	}
+
+	passphrase, err := s.passphrase(false)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read encryption key: %v", err)
+	}
+	gcm, err := secretsCipher(passphrase, enc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, enc.Nonce, enc.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt %v, is the passphrase correct?", s.file)
+	}
+
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (s fileSecretStore) write(secrets map[string]string) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := s.passphrase(true)
+	if err != nil {
+		return fmt.Errorf("Could not create encryption key: %v", err)
+	}
+	if s.keyNextToSecrets() {
+		Warnf("The encryption key %v is stored next to %v, so anyone who can read the directory can decrypt the secrets. "+
+			"Set %v_SECRETS_PASSPHRASE or a secrets_key_file on other storage instead.", s.keyFile, s.file, EnvPrefix)
+	}
+
+	enc := encryptedSecrets{Salt: make([]byte, 16)}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return err
+	}
+	gcm, err := secretsCipher(passphrase, enc.Salt)
+	if err != nil {
+		return err
+	}
+	enc.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return err
+	}
+	enc.Data = gcm.Seal(nil, enc.Nonce, plain, nil)
+
+	data, err := json.Marshal(enc)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.file, data, 0600)
+}
+
+// secretsCipher derives the AES key from passphrase and salt.
+func secretsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, 100000, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
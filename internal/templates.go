@@ -0,0 +1,306 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/tbruyelle/hipchat-go/hipchat"
+	"gopkg.in/yaml.v2"
+)
+
+// MessageTemplate is a notification with Go templates in its fields, defined
+// in the templates key of the config file or a file in the templates directory.
+type MessageTemplate struct {
+	Message string `json:"message"`
+	Format  string `json:"format,omitempty"`
+	Color   string `json:"color,omitempty"`
+	From    string `json:"from,omitempty"`
+	Notify  bool   `json:"notify,omitempty"`
+	// Card is rendered as a hipchat.Card.
+	Card map[string]interface{} `json:"card,omitempty"`
+}
+
+// messageTemplateFuncs are available in message templates.
+var messageTemplateFuncs = template.FuncMap{
+	"escape":   EscapeHTML,
+	"sanitize": SanitizeHTML,
+	"truncate": truncate,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"join":     strings.Join,
+	"split":    strings.Split,
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+	"env": templateEnv,
+	"now": time.Now,
+	"date": func(layout string, t interface{}) (string, error) {
+		parsed, err := cast.ToTimeE(t)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(layout), nil
+	},
+}
+
+// templateEnv returns the environment variable HIPCHAT_VAR_<name>. Other
+// variables are not available, as they may hold secrets like HIPCHAT_TOKEN.
+func templateEnv(name string) string {
+	return os.Getenv(EnvPrefix + "_VAR_" + name)
+}
+
+// truncate shortens s to at most n characters, ending it with "..." if it was cut.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string([]rune(s)[:n])
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+// TemplatesDir returns the directory with template files, set by templates_dir.
+func TemplatesDir() (string, error) {
+	if dir := viper.GetString("templates_dir"); dir != "" {
+		return dir, nil
+	}
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// LoadTemplate returns the template with the given name from the templates
+// key of the config file, or from the templates directory. Files named
+// <name>.yaml, <name>.yml or <name>.json define a MessageTemplate, files named
+// <name>.html or <name>.txt only its message in HTML or text.
+func LoadTemplate(name string) (*MessageTemplate, error) {
+	templates, err := ConfigTemplates(viper.Get("templates"))
+	if err != nil {
+		return nil, err
+	}
+	if t, found := templates[name]; found {
+		return t, nil
+	}
+
+	dir, err := TemplatesDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range []string{".yaml", ".yml", ".json", ".html", ".txt"} {
+		file := filepath.Join(dir, name+ext)
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t := &MessageTemplate{}
+		switch ext {
+		case ".html":
+			t.Message, t.Format = string(data), "html"
+		case ".txt":
+			t.Message, t.Format = string(data), "text"
+		default:
+			var generic interface{}
+			if err := yaml.Unmarshal(data, &generic); err != nil {
+				return nil, fmt.Errorf("Error while reading template %v: %v", file, err)
+			}
+			if t, err = toMessageTemplate(generic); err != nil {
+				return nil, fmt.Errorf("Error while reading template %v: %v", file, err)
+			}
+		}
+		Debugf("Using template %v", file)
+		return t, nil
+	}
+	return nil, fmt.Errorf("template %v is not defined in the config file or %v", name, dir)
+}
+
+// ConfigTemplates returns the templates defined by the value of the templates
+// key of the config file. A template is a MessageTemplate, or only its message.
+func ConfigTemplates(value interface{}) (map[string]*MessageTemplate, error) {
+	templates := map[string]*MessageTemplate{}
+	if value == nil {
+		return templates, nil
+	}
+	generic, err := cast.ToStringMapE(genericValue(value))
+	if err != nil {
+		return nil, fmt.Errorf("templates should be a map of template names to templates")
+	}
+	for name, v := range generic {
+		t, err := toMessageTemplate(v)
+		if err != nil {
+			return nil, fmt.Errorf("template %v: %v", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// toMessageTemplate converts a template read from YAML or JSON, and parses
+// its fields to report errors early.
+func toMessageTemplate(v interface{}) (*MessageTemplate, error) {
+	t := &MessageTemplate{}
+	if s, ok := v.(string); ok {
+		t.Message = s
+	} else {
+		data, err := json.Marshal(stringMapValue(v))
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(t); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Format != "" && t.Format != "html" && t.Format != "text" {
+		return nil, fmt.Errorf("format %v should be html or text", t.Format)
+	}
+	_, err := t.Render(nil, true)
+	return t, err
+}
+
+// Render executes the templates of t with vars and returns the notification.
+// The message of HTML templates and the HTML fields of the card escape the
+// values they include, unless they are passed through sanitize. With parseOnly the templates are only parsed.
+func (t *MessageTemplate) Render(vars map[string]string, parseOnly bool) (*hipchat.NotificationRequest, error) {
+	n := &hipchat.NotificationRequest{MessageFormat: t.Format, Notify: t.Notify}
+
+	var err error
+	if t.Format == "text" {
+		n.Message, err = renderText("message", t.Message, vars, parseOnly)
+	} else {
+		n.Message, err = renderHTML("message", t.Message, vars, parseOnly)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n.Color, err = renderColor(t.Color, vars, parseOnly); err != nil {
+		return nil, err
+	}
+	if n.From, err = renderText("from", t.From, vars, parseOnly); err != nil {
+		return nil, err
+	}
+
+	if t.Card == nil {
+		return n, nil
+	}
+	card, err := renderValue("card", t.Card, vars, parseOnly)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(card)
+	if err != nil {
+		return nil, err
+	}
+	n.Card = &hipchat.Card{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(n.Card); err != nil {
+		return nil, fmt.Errorf("card: %v", err)
+	}
+	return n, nil
+}
+
+func renderColor(color string, vars map[string]string, parseOnly bool) (hipchat.Color, error) {
+	s, err := renderText("color", color, vars, parseOnly)
+	return hipchat.Color(s), err
+}
+
+// renderValue renders every string in v, a value decoded from JSON.
+func renderValue(name string, v interface{}, vars map[string]string, parseOnly bool) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		return renderText(name, t, vars, parseOnly)
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, e := range t {
+			var r interface{}
+			var err error
+			if text, ok := e.(string); ok && isHTMLCardField(name+"."+k, t) {
+				r, err = renderHTML(name+"."+k, text, vars, parseOnly)
+			} else {
+				r, err = renderValue(name+"."+k, e, vars, parseOnly)
+			}
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			r, err := renderValue(fmt.Sprintf("%v[%d]", name, i), e, vars, parseOnly)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// isHTMLCardField reports if field of the card value v holds HTML, so the
+// values it includes must be escaped like in an HTML message.
+func isHTMLCardField(field string, v map[string]interface{}) bool {
+	return field == "card.activity.html" || field == "card.description.value" && v["format"] == "html"
+}
+
+func renderText(name, text string, vars map[string]string, parseOnly bool) (string, error) {
+	t, err := template.New(name).Funcs(messageTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil || parseOnly {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func renderHTML(name, text string, vars map[string]string, parseOnly bool) (string, error) {
+	funcs := htmltemplate.FuncMap{}
+	for k, f := range messageTemplateFuncs {
+		funcs[k] = f
+	}
+	// The results of sanitize and escape are included as is
+	funcs["sanitize"] = func(s string) htmltemplate.HTML { return htmltemplate.HTML(SanitizeHTML(s)) }
+	funcs["escape"] = func(s string) htmltemplate.HTML { return htmltemplate.HTML(EscapeHTML(s)) }
+
+	t, err := htmltemplate.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil || parseOnly {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	os.Setenv("HIPCHAT_VAR_DEPLOYER", "alice")
+	defer os.Unsetenv("HIPCHAT_VAR_DEPLOYER")
+	os.Setenv("HIPCHAT_TOKEN", "secret")
+	defer os.Unsetenv("HIPCHAT_TOKEN")
+
+	tmpl := &MessageTemplate{
+		Message: `{{.app}} deployed by {{env "DEPLOYER"}}{{env "TOKEN"}}`,
+		Card: map[string]interface{}{
+			"style":       "application",
+			"title":       "{{.app}}",
+			"description": map[string]interface{}{"format": "html", "value": "<b>{{.app}}</b>"},
+			"activity":    map[string]interface{}{"html": "<b>{{.app}}</b> {{sanitize .note}}"},
+		},
+	}
+	vars := map[string]string{"app": "<api>", "note": "<i>ok</i>"}
+	n, err := tmpl.Render(vars, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		field, got, want string
+	}{
+		{"message", n.Message, "&lt;api&gt; deployed by alice"},
+		{"title", n.Card.Title, "<api>"},
+		{"description", n.Card.Description.Value, "<b>&lt;api&gt;</b>"},
+		{"activity", n.Card.Activity.HTML, "<b>&lt;api&gt;</b> <i>ok</i>"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tbruyelle/hipchat-go/hipchat"
+)
+
+// tokenExpiryMargin is the time before the actual expiry at which a cached
+// token is considered stale and a new one is requested.
+const tokenExpiryMargin = 60 * time.Second
+
+// Settings of the lock of the token cache: how long to wait for another
+// process to release it, and the age after which it is considered stale.
+const (
+	tokenCacheLockWait = 10 * time.Second
+	tokenCacheLockAge  = time.Minute
+)
+
+// lockPollInterval is how often a lock held by another process is checked.
+const lockPollInterval = 50 * time.Millisecond
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// CachedToken is an OAuth access token stored in the token cache.
+type CachedToken struct {
+	OAuthID    string                   `json:"oauthid"`
+	Endpoint   string                   `json:"endpoint"`
+	Scopes     []string                 `json:"scopes"`
+	Token      hipchat.OAuthAccessToken `json:"token"`
+	ObtainedAt time.Time                `json:"obtained_at"`
+	ExpiresAt  time.Time                `json:"expires_at"`
+}
+
+// Valid reports if the token can still be used for at least tokenExpiryMargin.
+func (t CachedToken) Valid() bool {
+	return time.Now().Add(tokenExpiryMargin).Before(t.ExpiresAt)
+}
+
+// StateDir returns the directory where hipchat-cli keeps its state,
+// creating it with owner-only permissions if needed.
+func StateDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Could not determine home directory: %v", err)
+	}
+	dir := filepath.Join(home, ".hipchat-cli")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// TokenCacheFile returns the location of the token cache.
+func TokenCacheFile() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tokens.json"), nil
+}
+
+// ReadTokenCache returns all tokens in the cache, indexed by cache key.
+// A missing cache file results in an empty cache.
+func ReadTokenCache() (map[string]CachedToken, error) {
+	tokens := map[string]CachedToken{}
+
+	file, err := TokenCacheFile()
+	if err != nil {
+		return tokens, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return tokens, err
+	}
+
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return map[string]CachedToken{}, fmt.Errorf("Could not parse token cache %v: %v", file, err)
+	}
+	return tokens, nil
+}
+
+// WriteTokenCache replaces the token cache with tokens.
+// The cache is written to a temporary file first so concurrent invocations
+// never observe a partially written cache. Changes based on the cache read
+// before should be made holding lockTokenCache.
+func WriteTokenCache(tokens map[string]CachedToken) error {
+	file, err := TokenCacheFile()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data, 0600)
+}
+
+// tokenCacheKey identifies a token by the credentials, endpoint and scopes
+// it was requested with.
+func tokenCacheKey(oauthID, endpoint string, scopes []string) string {
+	sorted := append([]string{}, scopes...)
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(oauthID + "\n" + endpoint + "\n" + strings.Join(sorted, " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedToken returns a still valid token for key, or nil if there is none.
+func cachedToken(key string) *CachedToken {
+	tokens, err := ReadTokenCache()
+	if err != nil {
+		Warnf("Ignoring token cache: %v", err)
+		return nil
+	}
+
+	t, ok := tokens[key]
+	if !ok || !t.Valid() {
+		return nil
+	}
+	return &t
+}
+
+// lockTokenCache prevents concurrent invocations from changing the token
+// cache at the same time, which would lose the changes of all but one.
+// The returned function releases the lock.
+func lockTokenCache() (func(), error) {
+	file, err := TokenCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	return lockFile(file+".lock", tokenCacheLockWait, tokenCacheLockAge)
+}
+
+// storeToken adds token to the cache and drops expired entries.
+func storeToken(key string, t CachedToken) {
+	unlock, err := lockTokenCache()
+	if err != nil {
+		Warnf("Could not lock the token cache, not storing the access token: %v", err)
+		return
+	}
+	defer unlock()
+
+	tokens, err := ReadTokenCache()
+	if err != nil {
+		Warnf("Overwriting token cache: %v", err)
+	}
+
+	for k, v := range tokens {
+		if !v.Valid() {
+			delete(tokens, k)
+		}
+	}
+	tokens[key] = t
+
+	if err := WriteTokenCache(tokens); err != nil {
+		Warnf("Could not write token cache: %v", err)
+	}
+}
+
+// forgetToken removes the token for key from the cache.
+func forgetToken(key string) {
+	unlock, err := lockTokenCache()
+	if err != nil {
+		Warnf("Could not lock the token cache, not removing the access token: %v", err)
+		return
+	}
+	defer unlock()
+
+	tokens, err := ReadTokenCache()
+	if err != nil {
+		Warnf("Ignoring token cache: %v", err)
+		return
+	}
+	if _, ok := tokens[key]; !ok {
+		return
+	}
+	delete(tokens, key)
+
+	if err := WriteTokenCache(tokens); err != nil {
+		Warnf("Could not write token cache: %v", err)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and
+// renames it into place.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// lockFile creates the file lock, waiting up to wait while another process
+// holds it. A lock older than stale was not released by the process that
+// held it and is removed. The returned function releases the lock.
+func lockFile(lock string, wait, stale time.Duration) (func(), error) {
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintln(f, os.Getpid())
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > stale {
+			Warnf("Removing stale lock %v", lock)
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errLocked
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
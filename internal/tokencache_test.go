@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreTokenConcurrently(t *testing.T) {
+	home, err := ioutil.TempDir("", "hipchat-cli-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	// Like parallel invocations, which each read and write the cache
+	const count = 20
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			storeToken(strconv.Itoa(i), CachedToken{ExpiresAt: time.Now().Add(time.Hour)})
+		}(i)
+	}
+	wg.Wait()
+
+	tokens, err := ReadTokenCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != count {
+		t.Errorf("the token cache holds %d tokens, want %d", len(tokens), count)
+	}
+
+	forgetToken("0")
+	if tokens, _ := ReadTokenCache(); len(tokens) != count-1 {
+		t.Errorf("the token cache holds %d tokens after one was removed, want %d", len(tokens), count-1)
+	}
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Defaults of the connect_timeout and timeout config keys.
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultTimeout        = 30 * time.Second
+)
+
+// tlsVersions are the values of the tls_min_version config key.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// durationSetting returns the duration configured for key, or def if it is not set.
+func durationSetting(key string, def time.Duration) (time.Duration, error) {
+	if !viper.IsSet(key) {
+		return def, nil
+	}
+	d, err := ParseDuration(viper.Get(key))
+	if err != nil {
+		return 0, fmt.Errorf("%v: %v", key, err)
+	}
+	if d == 0 {
+		return def, nil
+	}
+	return d, nil
+}
+
+// newTransport returns the transport for requests to HipChat, with the
+// configured proxy, connect timeout and TLS settings.
+// Without a configured proxy, HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used.
+func newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy := viper.GetString("proxy"); proxy != "" {
+		proxyURL, err := ParseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		Debugf("Using proxy: %v", proxyURL)
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else {
+		Debugf("Not using a configured proxy, using the proxy environment variables if set")
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	connectTimeout, err := durationSetting("connect_timeout", defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// newTLSConfig returns the TLS settings from the config.
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if v := viper.GetString("tls_min_version"); v != "" {
+		version, ok := tlsVersions[v]
+		if !ok {
+			return nil, fmt.Errorf("tls_min_version %q should be 1.0, 1.1, 1.2 or 1.3", v)
+		}
+		config.MinVersion = version
+	}
+
+	if file := viper.GetString("ca_file"); file != "" {
+		pool, err := loadCAFile(file)
+		if err != nil {
+			return nil, err
+		}
+		Debugf("Using CA certificates from %v", file)
+		config.RootCAs = pool
+	}
+
+	cert, key := viper.GetString("client_cert"), viper.GetString("client_key")
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, fmt.Errorf("specify both client_cert and client_key")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate: %v", err)
+		}
+		Debugf("Using client certificate %v", cert)
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	if insecure, _ := cast.ToBoolE(viper.Get("insecure_skip_verify")); insecure {
+		Warnf("insecure_skip_verify is set: the certificate of the HipChat server is NOT verified, " +
+			"anyone able to intercept the connection can read your credentials and messages. Use ca_file instead.")
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+// loadCAFile returns the system certificate pool extended with the PEM
+// encoded certificates in file.
+func loadCAFile(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read ca_file: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca_file %v contains no PEM encoded certificates", file)
+	}
+	return pool, nil
+}